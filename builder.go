@@ -0,0 +1,96 @@
+package gigago
+
+// ChatBuilder assembles a ChatRequest with chainable calls, e.g.
+//
+//	req := gigago.NewChatBuilder("GigaChat").
+//		System("You are a helpful assistant.").
+//		User("What is the capital of France?").
+//		Temperature(0.5).
+//		Build()
+//
+// It only constructs the request; ChatRequest can still be filled in directly.
+type ChatBuilder struct {
+	req    ChatRequest
+	err    error
+	preset *Preset
+}
+
+// NewChatBuilder returns a ChatBuilder for a request to the given model.
+func NewChatBuilder(model string) *ChatBuilder {
+	return &ChatBuilder{req: ChatRequest{Model: model}}
+}
+
+// Model sets the model of the request.
+func (b *ChatBuilder) Model(model string) *ChatBuilder {
+	b.req.Model = model
+	return b
+}
+
+// System appends a system message.
+func (b *ChatBuilder) System(content string) *ChatBuilder {
+	return b.message(RoleSystem, content)
+}
+
+// SystemTemplate renders tmpl with data and appends the result as a system message.
+// If rendering fails, no message is added and the error is reported by Err.
+func (b *ChatBuilder) SystemTemplate(tmpl *PromptTemplate, data any) *ChatBuilder {
+	content, err := tmpl.Render(data)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	return b.System(content)
+}
+
+// Err returns the first error met while building, e.g. by SystemTemplate.
+// A request built while Err is not nil is incomplete and should not be sent.
+func (b *ChatBuilder) Err() error {
+	return b.err
+}
+
+// User appends a user message.
+func (b *ChatBuilder) User(content string) *ChatBuilder {
+	return b.message(RoleUser, content)
+}
+
+// Assistant appends an assistant message, e.g. a previous answer of the model.
+func (b *ChatBuilder) Assistant(content string) *ChatBuilder {
+	return b.message(RoleAssistant, content)
+}
+
+// Temperature sets the sampling temperature of the request; zero is sent as well.
+func (b *ChatBuilder) Temperature(temperature float64) *ChatBuilder {
+	b.req.Temperature = &temperature
+	return b
+}
+
+// TopP sets the nucleus sampling threshold of the request.
+func (b *ChatBuilder) TopP(topP float64) *ChatBuilder {
+	b.req.TopP = &topP
+	return b
+}
+
+// Preset fills the sampling parameters not set with Temperature or TopP from p,
+// see ChatRequest.ApplyPreset. Build applies it, so the order of calls does not matter.
+func (b *ChatBuilder) Preset(p Preset) *ChatBuilder {
+	b.preset = &p
+	return b
+}
+
+// Build returns the assembled request. Each call returns a new ChatRequest,
+// so the builder can be used further without affecting requests already built.
+func (b *ChatBuilder) Build() *ChatRequest {
+	req := b.req
+	req.Messages = append([]Message(nil), b.req.Messages...)
+	if b.preset != nil {
+		req.ApplyPreset(*b.preset)
+	}
+	return &req
+}
+
+func (b *ChatBuilder) message(role Role, content string) *ChatBuilder {
+	b.req.Messages = append(b.req.Messages, Message{Role: role, Content: content})
+	return b
+}
@@ -0,0 +1,387 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const chatCompletionsPath = "/chat/completions"
+
+// ChatRequest is a request to the chat completions endpoint.
+// Fields left at their zero value (nil for pointers) are omitted, so the server defaults apply.
+type ChatRequest struct {
+	// Model is the name of the model to use, e.g. "GigaChat" or "GigaChat-Pro".
+	Model string `json:"model"`
+
+	// Messages is the conversation so far, in chronological order.
+	Messages []Message `json:"messages"`
+
+	// Temperature is the sampling temperature. Higher values make the output more
+	// random, and zero makes it deterministic. A nil value keeps the server default.
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// MaxTokens is the maximum number of tokens to generate in the response.
+	MaxTokens int32 `json:"max_tokens,omitempty"`
+
+	// TopP is the nucleus sampling threshold: only the tokens comprising the top
+	// TopP probability mass are considered. A nil value keeps the server default.
+	TopP *float64 `json:"top_p,omitempty"`
+
+	// RepetitionPenalty penalizes repeated tokens; 1.0 means no penalty.
+	// A nil value keeps the server default.
+	RepetitionPenalty *float64 `json:"repetition_penalty,omitempty"`
+
+	// N is the number of candidate responses to generate.
+	// A nil value keeps the server default.
+	N *int `json:"n,omitempty"`
+
+	// ProfanityCheck enables or disables GigaChat's profanity filter for this request.
+	// A nil value keeps the server default.
+	ProfanityCheck *bool `json:"profanity_check,omitempty"`
+
+	// UpdateInterval is the minimum interval in seconds between chunks of a
+	// streamed response. A nil value keeps the server default.
+	UpdateInterval *float64 `json:"update_interval,omitempty"`
+
+	// Functions lists the functions the model may call.
+	Functions []FunctionDef `json:"functions,omitempty"`
+
+	// FunctionCall controls whether and which of the Functions the model calls.
+	FunctionCall FunctionCallMode `json:"function_call,omitzero"`
+
+	// Stream requests a server-sent events response. It is set by ChatStream;
+	// Chat rejects requests with Stream enabled.
+	Stream bool `json:"stream,omitempty"`
+
+	// Extra holds additional request parameters that ChatRequest does not model
+	// yet. They are merged into the request JSON, but fields of the struct take
+	// precedence: a key is ignored if the corresponding field is set, and only
+	// fills it in if the field is left unset and omitted.
+	Extra map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the request, merging Extra into the encoded fields.
+func (r ChatRequest) MarshalJSON() ([]byte, error) {
+	type plain ChatRequest
+	data, err := json.Marshal(plain(r))
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range r.Extra {
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode extra field %q: %w", key, err)
+		}
+		fields[key] = raw
+	}
+	return json.Marshal(fields)
+}
+
+// Ptr returns a pointer to v. It helps to set the optional fields of ChatRequest,
+// e.g. Temperature: gigago.Ptr(0.0).
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Validate checks the request before it is sent: Model and Messages must be set,
+// every message must have a known role, and a system message, if any, must come
+// first. Problems are returned as *ValidationError. Chat and ChatStream call
+// Validate themselves.
+func (r *ChatRequest) Validate() error {
+	if r == nil || len(r.Messages) == 0 {
+		return &ValidationError{Index: -1, Field: "messages", Message: "must not be empty"}
+	}
+	if r.Model == "" {
+		return &ValidationError{Index: -1, Field: "model", Message: "must not be empty"}
+	}
+
+	for i, msg := range r.Messages {
+		if !msg.Role.valid() {
+			return &ValidationError{Index: i, Field: "role", Message: fmt.Sprintf("unknown role %q", msg.Role)}
+		}
+		if msg.Role == RoleSystem && i > 0 {
+			return &ValidationError{Index: i, Field: "role", Message: "system message must be the first message"}
+		}
+	}
+	return nil
+}
+
+// ChatResponse represents the entire response from the GigaChat API for a chat completion request.
+type ChatResponse struct {
+	// Choices is a list of completion choices generated by the model. Typically, there is one choice.
+	Choices []Choice `json:"choices"`
+
+	// Created is the Unix timestamp (seconds) of when the response was created.
+	Created int64 `json:"created"`
+
+	// Model specifies the exact model version used to generate the response.
+	Model string `json:"model"`
+
+	// Usage provides statistics on token consumption for the request.
+	Usage Usage `json:"usage"`
+
+	// Object is the type of the API object, typically "chat.completion".
+	Object string `json:"object"`
+
+	// RequestID is the ID the API assigned to the request (the X-Request-ID
+	// response header), if any. Include it when contacting GigaChat support.
+	RequestID string `json:"-"`
+}
+
+func (r *ChatResponse) setRequestID(id string) { r.RequestID = id }
+
+// BestChoice returns the choice at index 0, the primary completion when several
+// were requested with ChatRequest.N. It returns ErrNoChoices if there are none.
+func (r *ChatResponse) BestChoice() (*Choice, error) {
+	for i := range r.Choices {
+		if r.Choices[i].Index == 0 {
+			return &r.Choices[i], nil
+		}
+	}
+	if len(r.Choices) > 0 {
+		return &r.Choices[0], nil
+	}
+	return nil, ErrNoChoices
+}
+
+// Choice represents a single completion alternative.
+type Choice struct {
+	// Message is the actual message object generated by the model.
+	Message ResponseMessage `json:"message"`
+
+	// Index is the position of this choice in the list, starting from 0.
+	Index int `json:"index"`
+
+	// FinishReason indicates why the model stopped generating tokens.
+	// Possible values include: "stop", "length", "function_call", "blacklist", "error".
+	FinishReason string `json:"finish_reason"`
+}
+
+// Values of Choice.FinishReason and ChunkChoice.FinishReason.
+const (
+	FinishReasonStop         = "stop"
+	FinishReasonLength       = "length"
+	FinishReasonFunctionCall = "function_call"
+	// FinishReasonBlacklist means the content was blocked by GigaChat's moderation
+	// and the message holds a canned refusal instead of the model's answer.
+	FinishReasonBlacklist = "blacklist"
+	FinishReasonError     = "error"
+)
+
+// Blocked reports whether the choice was blocked by GigaChat's content moderation
+// (FinishReason "blacklist"). Its Message.Content is not a real answer then.
+func (ch *Choice) Blocked() bool {
+	return ch.FinishReason == FinishReasonBlacklist
+}
+
+// ResponseMessage represents a message generated by the assistant.
+// It can contain either text content or a request to call a function.
+type ResponseMessage struct {
+	// Role is the role of the message author, always "assistant" for responses.
+	Role Role `json:"role"`
+
+	// Content is the textual content of the message.
+	Content string `json:"content"`
+
+	// FunctionCall, if not nil, indicates that the model wants to call a function.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// FunctionsStateID identifies the function call so its result can be matched to it.
+	FunctionsStateID string `json:"functions_state_id,omitempty"`
+}
+
+// Message converts the response into a Message that can be appended to the
+// conversation history, preserving any function call so its result can follow.
+func (m ResponseMessage) Message() Message {
+	return Message{
+		Role:             m.Role,
+		Content:          m.Content,
+		FunctionCall:     m.FunctionCall,
+		FunctionsStateID: m.FunctionsStateID,
+	}
+}
+
+// FunctionCall represents a model's request to invoke a specific tool or function.
+type FunctionCall struct {
+	// Name is the name of the function to be called.
+	Name string `json:"name"`
+
+	// Arguments is a JSON object containing the arguments for the function call.
+	// It is provided as a json.RawMessage to allow for flexible deferred parsing
+	// into a user-defined struct.
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// Usage contains detailed statistics on token usage for a request.
+type Usage struct {
+	// PromptTokens is the number of tokens in the input messages.
+	PromptTokens int `json:"prompt_tokens"`
+
+	// CompletionTokens is the number of tokens generated by the model.
+	CompletionTokens int `json:"completion_tokens"`
+
+	// PrecachedPromptTokens is the number of cached tokens from the prompt that were
+	// reused to process the request, reducing cost and latency.
+	PrecachedPromptTokens int `json:"precached_prompt_tokens"`
+
+	// TotalTokens is the total number of tokens billed for the request,
+	// calculated after subtracting any precached tokens.
+	TotalTokens int `json:"total_tokens"`
+}
+
+// Chat sends a chat completion request and returns the model's response.
+//
+// To use function calling, list the functions in req.Functions. If the model decides
+// to call one, the choice's Message.FunctionCall is set and FinishReason is
+// "function_call". Execute the function, then append the assistant message
+// (see ResponseMessage.Message) and a RoleFunction message with the function's
+// Name and its JSON result as Content, and call Chat again to get the final answer.
+//
+// The access token is refreshed beforehand if needed, and the request is retried
+// once after a token refresh if the API responds with 401 Unauthorized.
+// Non-2xx responses are returned as *APIError.
+// Defaults set with WithDefaultModel and WithModelDefaults fill the fields left
+// unset in req.
+// To continue answers truncated by MaxTokens, pass WithAutoContinue; to drop
+// the oldest messages of a long history, WithAutoTrim.
+// Prompts exceeding a limit set with WithModelContextLimit fail with ErrContextTooLong.
+func (c *Client) Chat(ctx context.Context, req *ChatRequest, opts ...RequestOption) (*ChatResponse, error) {
+	req = c.withDefaultModel(req)
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if req.Stream {
+		return nil, fmt.Errorf("streaming requests must be sent with ChatStream")
+	}
+	req = c.applyModelDefaults(req)
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	o := newRequestOptions(nil, opts)
+	if o.autoTrim > 0 {
+		var err error
+		if req, err = c.trimToTokens(ctx, req, o.autoTrim, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkContextLimit(ctx, req, opts); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.chat(ctx, req, opts)
+	if err != nil {
+		return nil, err
+	}
+	if limit := o.autoContinue; limit > 0 {
+		return c.continueChat(ctx, req, resp, limit, opts)
+	}
+	return resp, nil
+}
+
+// WithResponseValidator provides an Option to check every Chat response against
+// application-specific criteria, e.g. that the content is not empty. If validate
+// returns an error, the response is discarded and the request is sent again, up to
+// maxAttempts requests in total; once they are used up, Chat returns the error of
+// validate. maxAttempts must be positive, 1 disables resending. Rejected responses
+// still count towards the usage (see WithUsageTracker). It does not apply to ChatStream.
+func WithResponseValidator(validate func(*ChatResponse) error, maxAttempts int) Option {
+	return func(c *Client) {
+		c.responseValidator = validate
+		c.responseValidatorAttempts = maxAttempts
+	}
+}
+
+// chat sends a single chat completion request, repeating it while the response is
+// rejected by the validator set with WithResponseValidator.
+func (c *Client) chat(ctx context.Context, req *ChatRequest, opts []RequestOption) (*ChatResponse, error) {
+	ctx, span := c.startSpan(ctx, "gigago.Chat", req.Model)
+	for attempt := 1; ; attempt++ {
+		var resp ChatResponse
+		err := c.doRequest(ctx, http.MethodPost, chatCompletionsPath, req, &resp, opts)
+		if err != nil {
+			span.End(err)
+			return nil, err
+		}
+		c.usageTracker.Add(resp.Usage)
+
+		if c.responseValidator != nil {
+			if err := c.responseValidator(&resp); err != nil {
+				if attempt < c.responseValidatorAttempts && ctx.Err() == nil {
+					continue
+				}
+				span.End(err)
+				return nil, err
+			}
+		}
+		span.End(nil)
+		return &resp, nil
+	}
+}
+
+// WithAutoContinue provides a RequestOption for Chat to continue answers truncated
+// by MaxTokens. While the answer finishes with FinishReasonLength, Chat appends it
+// to the conversation as an assistant message and requests a continuation, up to
+// maxContinuations times. The returned response holds the concatenated content,
+// the finish reason of the last part and the usage summed over all requests.
+//
+// It only applies to responses with a single choice, i.e. without ChatRequest.N.
+func WithAutoContinue(maxContinuations int) RequestOption {
+	return func(o *requestOptions) {
+		o.autoContinue = maxContinuations
+	}
+}
+
+// continueChat requests up to limit continuations of resp, an answer to req,
+// as described by WithAutoContinue.
+func (c *Client) continueChat(ctx context.Context, req *ChatRequest, resp *ChatResponse, limit int, opts []RequestOption) (*ChatResponse, error) {
+	for range limit {
+		if len(resp.Choices) != 1 || resp.Choices[0].FinishReason != FinishReasonLength {
+			break
+		}
+
+		next := *req
+		next.Messages = append(slices.Clone(req.Messages), resp.Choices[0].Message.Message())
+		part, err := c.chat(ctx, &next, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(part.Choices) != 1 {
+			return nil, fmt.Errorf("continuation returned %d choices", len(part.Choices))
+		}
+
+		part.Choices[0].Message.Content = resp.Choices[0].Message.Content + part.Choices[0].Message.Content
+		part.Usage.add(resp.Usage)
+		resp = part
+	}
+	return resp, nil
+}
+
+// Complete sends prompt as a single user message to model and returns the text of
+// the best choice (see ChatResponse.BestChoice). It is a shortcut over Chat for simple prompts; use Chat to
+// control the request or inspect the full response.
+func (c *Client) Complete(ctx context.Context, model, prompt string, opts ...RequestOption) (string, error) {
+	resp, err := c.Chat(ctx, &ChatRequest{
+		Model:    model,
+		Messages: []Message{{Role: RoleUser, Content: prompt}},
+	}, opts...)
+	if err != nil {
+		return "", err
+	}
+	choice, err := resp.BestChoice()
+	if err != nil {
+		return "", err
+	}
+	return choice.Message.Content, nil
+}
@@ -3,27 +3,54 @@ package gigago
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
-	defaultBaseURLForAI    = "https://gigachat.devices.sberbank.ru/api/v1/chat/completions"
+	defaultBaseURLForAI    = "https://gigachat.devices.sberbank.ru/api/v1"
 	defaultBaseURLForOauth = "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
 	defaultTimeout         = 30 * time.Second
-	defaultScope           = "GIGACHAT_API_PERS"
+	defaultScope           = ScopePersonal
+)
+
+// OAuth scopes for the different GigaChat API tiers.
+const (
+	// ScopePersonal is the scope for individuals.
+	ScopePersonal = "GIGACHAT_API_PERS"
+	// ScopeB2B is the scope for legal entities with prepaid packages.
+	ScopeB2B = "GIGACHAT_API_B2B"
+	// ScopeCorp is the scope for legal entities with pay-as-you-go billing.
+	ScopeCorp = "GIGACHAT_API_CORP"
 )
 
 // Client is the main entry point for interacting with the GigaChat API.
 // It manages authentication, token refreshing, and request sending.
 //
 // A Client should be created using the NewClient function.
+//
+// A Client is safe for concurrent use by multiple goroutines, and a single Client
+// should be shared rather than created per request. The access token is guarded
+// internally, and concurrent refreshes, including those triggered by 401 responses,
+// are coalesced into one OAuth request. The configuration is fixed by NewClient and
+// never changes afterwards. Values passed to or returned from methods, such as
+// *ChatRequest, and a single ChatStream are not safe for concurrent use.
 type Client struct {
 	// httpClient is the underlying HTTP client used for requests.
 	httpClient *http.Client
-	// baseURLAI is the base URL for the main chat completions API.
+	// streamHTTPClient is httpClient without its Timeout, used for streams,
+	// whose body is read for as long as the generation runs.
+	streamHTTPClient *http.Client
+	// baseURLAI is the base URL for the GigaChat API; endpoint paths are appended to it.
 	baseURLAI string
 	// baseURLOauth is the base URL for the OAuth 2.0 token endpoint.
 	baseURLOauth string
@@ -32,30 +59,145 @@ type Client struct {
 	apiKey         string
 	mu             sync.RWMutex
 	wg             *sync.WaitGroup
-	accessToken    *tokenResponse
+	accessToken    *Token
 	ctxCancel      context.CancelFunc
 	refreshMu      sync.Mutex
 	refreshing     bool
 	refreshWaiters []chan error
+	// skipScopeCheck disables validation of scope against the known scopes.
+	skipScopeCheck bool
+	// requestTimeout is the default deadline for API calls whose context has none.
+	requestTimeout time.Duration
+	// limiter paces API calls if a rate limit is configured.
+	limiter *rate.Limiter
+	// maxConcurrentRequests is the limit set with WithMaxConcurrentRequests.
+	maxConcurrentRequests int
+	// requestSlots holds a value for every API call in flight if concurrency is limited.
+	requestSlots chan struct{}
+	// rateLimitMaxRetries is how many times a response with a retryable status is retried.
+	rateLimitMaxRetries int
+	// responseValidator checks Chat responses; rejected responses are retried
+	// up to responseValidatorAttempts requests in total.
+	responseValidator         func(*ChatResponse) error
+	responseValidatorAttempts int
+	// retryableStatusCodes are the statuses to retry; nil means defaultRetryableStatusCodes.
+	retryableStatusCodes []int
+	// usageTracker accumulates token usage of chat responses, if set.
+	usageTracker *UsageTracker
+	// refreshBuffer is how long before expiration the token is considered stale.
+	refreshBuffer time.Duration
+	// compression enables requesting and decoding gzip-compressed responses.
+	compression bool
+	// maxResponseBytes is the maximum size of a response body; zero means no limit.
+	maxResponseBytes int64
+	// streamRefreshBuffer is the minimum remaining token lifetime for starting a stream.
+	streamRefreshBuffer time.Duration
+	// refreshInterval is how often the background refresher checks the token.
+	refreshInterval time.Duration
+	// refreshJitter is the maximum random delay added to each refresh interval.
+	refreshJitter time.Duration
+	// refreshMaxAttempts is the maximum number of attempts for a single token refresh.
+	refreshMaxAttempts int
+	// refreshBaseDelay is the delay before the first refresh retry; it doubles on each attempt.
+	refreshBaseDelay time.Duration
+	// refreshErrorHandler receives errors from the background token refresher.
+	refreshErrorHandler func(error)
+	// beforeRefresh and afterRefresh are called around every OAuth token request.
+	beforeRefresh func(context.Context)
+	afterRefresh  func(context.Context, *Token, error)
+	// logger receives the client's internal log output.
+	logger Logger
+	// requestLogger, if set, receives a line for every outgoing request.
+	requestLogger Logger
+	// requestLogBodySize is the maximum number of body bytes in a request log line.
+	requestLogBodySize int
+	// userAgent is sent in the User-Agent header of OAuth and API requests.
+	userAgent string
+	// defaultHeaders are set on every API request.
+	defaultHeaders http.Header
+	// certAuth reports whether the client authenticates with a TLS client certificate
+	// instead of an authorization key.
+	certAuth bool
+	// credentials is the pool of authorization keys set with WithCredentialPool,
+	// and activeCredential the index of the one in use.
+	credentials      []Credential
+	activeCredential atomic.Int32
+	// requestInterceptors and responseInterceptors run around every HTTP request.
+	requestInterceptors  []func(*http.Request)
+	responseInterceptors []func(*http.Response)
+	// metrics receives the latency and status of every HTTP request.
+	metrics Metrics
+	// tracer creates spans around API calls and token refreshes, if set.
+	tracer Tracer
+	// tokenStore caches access tokens across process restarts, if set.
+	tokenStore TokenStore
+	// defaultModel is the model of requests that don't set one.
+	defaultModel string
+	// modelDefaults holds the default request parameters per model.
+	modelDefaults map[string]ChatRequest
+	// idempotencyHeader is the header carrying the idempotency key of POST requests, if set.
+	idempotencyHeader string
+	// modelContextLimits holds the prompt token limit per model checked by Chat.
+	modelContextLimits map[string]int
+	// clock is the source of time for token handling; nil means the system clock.
+	clock Clock
+	// noBackgroundRefresh disables the background token refresher.
+	noBackgroundRefresh bool
+	// lenientStart makes NewClient log a failed initial token fetch instead of failing.
+	lenientStart bool
+	// embeddingBatchSize is the maximum number of texts per embeddings request; 0 disables batching.
+	embeddingBatchSize int
+	// embeddingConcurrency is how many embeddings batches are sent in parallel.
+	embeddingConcurrency int
+	// closeOnce guards Close against being run more than once.
+	closeOnce sync.Once
+	// closed reports whether Close has been called.
+	closed atomic.Bool
+	// maxIdleConnsPerHost and idleConnTimeout tune the connection pool of the
+	// default transport; zero keeps the transport's own value.
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	// stopCloseOnDone stops closing the client when the NewClient context is done.
+	stopCloseOnDone func() bool
 	// for testing
-	oauthCreateFunc func(ctx context.Context) (*tokenResponse, error)
+	oauthCreateFunc func(ctx context.Context) (*Token, error)
 }
 
 // Option is a function type used to configure a Client.
 // It's used in the NewClient constructor to customize client behavior.
 type Option func(*Client)
 
-// WithCustomURLAI provides an Option to set a custom base URL for the main AI API.
-// This is primarily used for testing or connecting to a proxy.
+// WithCustomURLAI provides an Option to set a custom URL for the chat completions
+// endpoint, e.g. "https://gigachat.devices.sberbank.ru/api/v1/chat/completions".
+// The other endpoints are resolved against the same base URL. A URL without the
+// "/chat/completions" suffix is taken as the base URL itself.
+//
+// Deprecated: Use WithAPIBaseURL, which takes the base URL of the API.
 func WithCustomURLAI(url string) Option {
+	return WithAPIBaseURL(strings.TrimSuffix(strings.TrimRight(url, "/"), chatCompletionsPath))
+}
+
+// WithAPIBaseURL provides an Option to set a custom base URL for the GigaChat API,
+// e.g. "https://gigachat.devices.sberbank.ru/api/v1". Endpoint paths such as
+// "/chat/completions" are appended to it. NewClient returns an error if the URL is malformed.
+// This is primarily used for testing or connecting to a proxy.
+func WithAPIBaseURL(url string) Option {
 	return func(c *Client) {
-		c.baseURLAI = url
+		c.baseURLAI = strings.TrimRight(url, "/")
 	}
 }
 
-// WithCustomURLOauth provides an Option to set a custom base URL for the OAuth 2.0 endpoint.
-// This is primarily used for testing or connecting to a proxy.
+// WithCustomURLOauth provides an Option to set a custom URL for the OAuth 2.0 endpoint.
+//
+// Deprecated: Use WithOAuthURL.
 func WithCustomURLOauth(url string) Option {
+	return WithOAuthURL(url)
+}
+
+// WithOAuthURL provides an Option to set a custom URL for the OAuth 2.0 token endpoint,
+// e.g. "https://ngw.devices.sberbank.ru:9443/api/v2/oauth". NewClient returns an error
+// if the URL is malformed. This is primarily used for testing or connecting to a proxy.
+func WithOAuthURL(url string) Option {
 	return func(c *Client) {
 		c.baseURLOauth = url
 	}
@@ -66,6 +208,13 @@ func WithCustomURLOauth(url string) Option {
 // transport for proxies or mTLS. If this option is used, it should typically
 // be the FIRST option passed to NewClient, as other options like WithCustomTimeout
 // or WithCustomInsecureSkipVerify will modify the provided client.
+//
+// The client is used for all outbound requests, including OAuth token requests.
+// Its Timeout applies to every request in addition to any deadline on the context
+// passed to API methods; whichever expires first aborts the request. Streams opened
+// with ChatStream are exempt from the Timeout, since it would cut them off mid-generation:
+// bound them with a context deadline, or with the ResponseHeaderTimeout of the
+// client's transport for the wait until the first byte.
 func WithCustomClient(client *http.Client) Option {
 	return func(c *Client) {
 		c.httpClient = client
@@ -75,6 +224,8 @@ func WithCustomClient(client *http.Client) Option {
 // WithCustomTimeout provides an Option to set a custom timeout for the http.Client.
 // If WithCustomClient is also used, this option will be applied to the custom client,
 // potentially overwriting its original timeout.
+// Streams opened with ChatStream are not cut off by the timeout; with the default
+// client it bounds only the wait for their response headers.
 func WithCustomTimeout(timeout time.Duration) Option {
 	return func(c *Client) {
 		if c.httpClient == nil {
@@ -85,10 +236,23 @@ func WithCustomTimeout(timeout time.Duration) Option {
 }
 
 // WithCustomScope provides an Option to set a custom scope for OAuth 2.0 authorization.
+// The scope must be one of ScopePersonal, ScopeB2B, or ScopeCorp; NewClient returns
+// an error otherwise. Use WithUnverifiedScope for scopes this package doesn't know yet.
 // Defaults to "GIGACHAT_API_PERS" if not specified.
 func WithCustomScope(scope string) Option {
 	return func(c *Client) {
 		c.scope = scope
+		c.skipScopeCheck = false
+	}
+}
+
+// WithUnverifiedScope provides an Option to set an OAuth 2.0 scope without checking it
+// against the scopes known to this package. It is an escape hatch for newly
+// introduced GigaChat tiers; prefer WithCustomScope otherwise.
+func WithUnverifiedScope(scope string) Option {
+	return func(c *Client) {
+		c.scope = scope
+		c.skipScopeCheck = true
 	}
 }
 
@@ -99,77 +263,487 @@ func WithCustomScope(scope string) Option {
 // By default, verification is enabled (false).
 func WithCustomInsecureSkipVerify(insecureSkipVerify bool) Option {
 	return func(c *Client) {
-		if c.httpClient == nil {
-			c.httpClient = &http.Client{}
+		c.tlsConfig().InsecureSkipVerify = insecureSkipVerify
+	}
+}
+
+// WithTLSConfig provides an Option to set the TLS configuration used for both
+// OAuth and API requests, e.g. to present client certificates or pin a minimum
+// TLS version. The config is cloned, so later changes to it have no effect.
+// It replaces any TLS settings made by options applied before it.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.transport().TLSClientConfig = config.Clone()
+	}
+}
+
+// WithRootCAs provides an Option to set the certificate authorities used to verify
+// the GigaChat servers. GigaChat endpoints are signed by the Russian Trusted Root CA,
+// which is missing from many system trust stores; load it into a pool and pass it here
+// instead of disabling verification with WithCustomInsecureSkipVerify.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.tlsConfig().RootCAs = pool
+	}
+}
+
+// WithClientCertificate provides an Option to authenticate with a TLS client
+// certificate (mutual TLS), as used by corporate GigaChat accounts, instead of an
+// authorization key. The certificate is presented on OAuth and API requests, and
+// the OAuth request carries no Authorization header. Pass an empty apiKey to
+// NewClient when using it: the two auth modes are mutually exclusive.
+//
+// The certificate is added to the TLS configuration of the client's transport,
+// so use it after WithCustomClient and WithTLSConfig.
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(c *Client) {
+		config := c.tlsConfig()
+		config.Certificates = append(config.Certificates, cert)
+		c.certAuth = true
+	}
+}
+
+// WithProxy provides an Option to send OAuth and API requests through the HTTP(S)
+// proxy at proxyURL, e.g. "http://proxy.corp.local:3128". Credentials can be set in
+// the URL's user info. Without it, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables. Passing nil disables proxying altogether.
+//
+// The proxy is set on the transport of the client's HTTP client, so use it after
+// WithCustomClient; a custom client whose transport is not an *http.Transport has
+// it replaced by one.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *Client) {
+		if proxyURL == nil {
+			c.transport().Proxy = nil
+			return
 		}
+		c.transport().Proxy = http.ProxyURL(proxyURL)
+	}
+}
 
-		transport, ok := c.httpClient.Transport.(*http.Transport)
-		if !ok {
-			transport = &http.Transport{}
-			c.httpClient.Transport = transport
+// transport returns the *http.Transport of the client's HTTP client,
+// creating the client and transport if they are not set yet. A created
+// transport gets the defaults of newDefaultTransport.
+func (c *Client) transport() *http.Transport {
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+	}
+
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = newDefaultTransport()
+		c.httpClient.Transport = transport
+	}
+	return transport
+}
+
+// tlsConfig returns the TLS configuration of the client's transport, creating it if needed.
+func (c *Client) tlsConfig() *tls.Config {
+	transport := c.transport()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	return transport.TLSClientConfig
+}
+
+// WithMaxIdleConnsPerHost provides an Option to set how many idle connections to
+// each host the default transport keeps for reuse. Go's default of 2 is small for
+// GigaChat, which serves all API calls from a single host: a service making many
+// concurrent calls should set it to about its usual concurrency, e.g. 32 to 100,
+// since every connection beyond the limit costs a new TLS handshake. The total
+// idle limit of the transport is raised to match if needed.
+//
+// It is ignored if a custom HTTP client is set with WithCustomClient: configure its
+// transport directly. Zero keeps Go's default; the value must not be negative.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.maxIdleConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout provides an Option to set how long an idle connection of the
+// default transport is kept before it is closed. Go's default of 90 seconds suits
+// most services; a longer timeout helps services with sparse traffic avoid new TLS
+// handshakes. It is ignored if a custom HTTP client is set with WithCustomClient.
+// Zero keeps Go's default; the value must not be negative.
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleConnTimeout = d
+	}
+}
+
+// tuneConnectionPool applies the settings of WithMaxIdleConnsPerHost and
+// WithIdleConnTimeout to transport.
+func (c *Client) tuneConnectionPool(transport *http.Transport) {
+	if c.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+		if transport.MaxIdleConns != 0 && transport.MaxIdleConns < c.maxIdleConnsPerHost {
+			transport.MaxIdleConns = c.maxIdleConnsPerHost
 		}
+	}
+	if c.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = c.idleConnTimeout
+	}
+}
+
+// WithUserAgent provides an Option to replace the User-Agent header sent with
+// OAuth and API requests, which defaults to "gigago/<Version>". To identify your
+// application while keeping the SDK version, append it:
+//
+//	gigago.WithUserAgent("myapp/1.2 gigago/" + gigago.Version)
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
 
-		if transport.TLSClientConfig == nil {
-			transport.TLSClientConfig = &tls.Config{}
+// WithDefaultHeaders provides an Option to set extra headers on every API request,
+// e.g. X-Client-ID. They override the client's own headers except Authorization,
+// and are overridden by headers passed to a call with WithHeader. OAuth requests
+// do not carry them. Calling it more than once merges the headers.
+func WithDefaultHeaders(headers map[string]string) Option {
+	return func(c *Client) {
+		if c.defaultHeaders == nil {
+			c.defaultHeaders = http.Header{}
+		}
+		for key, value := range headers {
+			c.defaultHeaders.Set(key, value)
 		}
+	}
+}
+
+// WithMaxResponseBytes provides an Option to limit the size of response bodies to
+// n bytes, protecting against a misbehaving endpoint exhausting memory. Reading
+// beyond the limit fails with ErrResponseTooLarge. The limit applies to each
+// response as a whole: for ChatStream it caps the total size of the stream, and
+// it also applies to DownloadFile and OAuth responses.
+// Defaults to 64 MiB. The value must be positive.
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) {
+		c.maxResponseBytes = n
+	}
+}
+
+// WithRequestTimeout provides an Option to bound each non-streaming API call
+// (Chat, Embeddings, Models, ...) by a timeout when the caller's context has no
+// deadline of its own. A deadline already set on the context always takes precedence.
+// The timeout covers the whole call: token refreshes and retries made during it, and
+// the extra requests of WithAutoTrim, WithAutoContinue, WithResponseValidator and
+// batched Embeddings share the same deadline.
+// Streams opened with ChatStream are not affected, and background token refreshes
+// keep their own 30-second timeout. Defaults to no timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.requestTimeout = d
+	}
+}
+
+// WithTokenRefreshBuffer provides an Option to set how long before expiration
+// the access token is considered stale and gets refreshed.
+// Defaults to 15 minutes. The value must be positive.
+func WithTokenRefreshBuffer(d time.Duration) Option {
+	return func(c *Client) {
+		c.refreshBuffer = d
+	}
+}
+
+// WithStreamRefreshBuffer provides an Option to set the minimum remaining lifetime
+// of the access token for starting a ChatStream. If the token would expire sooner,
+// it is refreshed before the stream is opened, and a failed refresh fails ChatStream,
+// so that a long stream cannot outlive its token.
+// Defaults to 90 seconds, the refresh timeout plus a minute. Zero disables the check.
+func WithStreamRefreshBuffer(d time.Duration) Option {
+	return func(c *Client) {
+		c.streamRefreshBuffer = d
+	}
+}
+
+// WithTokenRefreshInterval provides an Option to set how often the background
+// refresher checks whether the access token needs to be refreshed.
+// Defaults to 1 minute. The value must be positive.
+func WithTokenRefreshInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.refreshInterval = d
+	}
+}
+
+// WithRefreshJitter provides an Option to randomize the background refresh schedule.
+// Each check is delayed by the refresh interval plus a random duration of up to max,
+// which keeps many clients started at the same time from hitting the OAuth endpoint together.
+// Defaults to zero (no jitter).
+func WithRefreshJitter(max time.Duration) Option {
+	return func(c *Client) {
+		c.refreshJitter = max
+	}
+}
+
+// WithRefreshRetry provides an Option to retry failed token refreshes with exponential backoff.
+// A refresh is attempted up to maxAttempts times, waiting baseDelay before the first retry
+// and doubling the delay each time. Only transport errors and 429/5xx responses are retried;
+// rejected credentials fail immediately. Defaults to a single attempt (no retries).
+func WithRefreshRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		c.refreshMaxAttempts = maxAttempts
+		c.refreshBaseDelay = baseDelay
+	}
+}
 
-		transport.TLSClientConfig.InsecureSkipVerify = insecureSkipVerify
+// WithRefreshOnStart provides an Option to configure the token fetch NewClient
+// performs before returning, so that the first request does not wait for OAuth.
+// With mustSucceed, the default, a failed fetch fails NewClient. Without it, the
+// error is logged and NewClient returns a client without a token, which fetches
+// one on the first request or the next tick of the background refresher; this
+// lets an application start while the OAuth endpoint is unavailable.
+func WithRefreshOnStart(mustSucceed bool) Option {
+	return func(c *Client) {
+		c.lenientStart = !mustSucceed
 	}
 }
 
+// WithoutBackgroundRefresh provides an Option to not start the background token
+// refresher, e.g. in serverless environments where a long-lived goroutine is
+// undesirable. The token is then refreshed on demand by the first request made
+// once it is about to expire, which adds the latency of an OAuth round trip to
+// that request. Close is still required and safe to call.
+func WithoutBackgroundRefresh() Option {
+	return func(c *Client) {
+		c.noBackgroundRefresh = true
+	}
+}
+
+// WithRefreshErrorHandler provides an Option to receive errors that occur while the
+// token is refreshed in the background. The handler is called from the refresher
+// goroutine, so it should return quickly. If no handler is set, errors are logged.
+func WithRefreshErrorHandler(handler func(error)) Option {
+	return func(c *Client) {
+		c.refreshErrorHandler = handler
+	}
+}
+
+// WithBeforeRefresh provides an Option to call hook right before the client requests
+// a new access token, whether in NewClient, on demand or in the background. Concurrent callers
+// that share a refresh trigger a single call. The hook runs synchronously with the
+// ctx of the refresh and without holding the client's locks, so it may call the
+// client, but it delays the refresh and should return quickly.
+func WithBeforeRefresh(hook func(ctx context.Context)) Option {
+	return func(c *Client) {
+		c.beforeRefresh = hook
+	}
+}
+
+// WithAfterRefresh provides an Option to call hook once a token refresh has finished,
+// with the new token or nil and the error of the refresh, including its retries.
+// On success the token is already in use by the client when hook is called.
+// See WithBeforeRefresh for when and how hooks are called.
+func WithAfterRefresh(hook func(ctx context.Context, token *Token, err error)) Option {
+	return func(c *Client) {
+		c.afterRefresh = hook
+	}
+}
+
+// newDefaultTransport returns a copy of http.DefaultTransport, which comes with
+// dial, TLS handshake, and idle connection timeouts and honors the proxy
+// environment variables, with certificate verification explicitly enabled.
+func newDefaultTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: false,
+	}
+	return transport
+}
+
 // NewClient creates, configures, and returns a new Client instance.
-// It requires an API key for authentication and accepts a variadic number of
+// It requires an API key for authentication, unless a client certificate is
+// configured with WithClientCertificate, and accepts a variadic number of
 // Option functions to customize its behavior (e.g., setting custom URLs or HTTP client).
 //
-// On initialization, it performs an initial request to obtain an access token.
-// It also launches a background goroutine to automatically refresh the token before it expires.
+// On initialization, it performs an initial request to obtain an access token,
+// unless a valid one is found in the store set with WithTokenStore.
+// It also launches a background goroutine to automatically refresh the token before it expires,
+// unless WithoutBackgroundRefresh is used.
 // An error is returned if the initial token fetch fails.
+//
+// ctx bounds both the initial token fetch and the lifetime of the client: once
+// ctx is done, the client is closed as if Close had been called, so passing the
+// application's root context gives a clean shutdown without an explicit Close.
+// To limit only the initial fetch, e.g. with a timeout, strip the cancellation
+// from the lifetime with context.WithoutCancel and set a deadline on the result.
 func NewClient(ctx context.Context, apiKey string, opts ...Option) (*Client, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("apiKey cannot be empty")
+	defaultHTTPClient := &http.Client{
+		Transport: newDefaultTransport(),
+		Timeout:   defaultTimeout,
 	}
-
 	client := &Client{
-		apiKey:       apiKey,
-		baseURLAI:    defaultBaseURLForAI,
-		baseURLOauth: defaultBaseURLForOauth,
-		scope:        defaultScope,
-		httpClient: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: false,
-				},
-			},
-			Timeout: defaultTimeout,
-		},
-		wg: &sync.WaitGroup{},
+		apiKey:              apiKey,
+		baseURLAI:           defaultBaseURLForAI,
+		baseURLOauth:        defaultBaseURLForOauth,
+		scope:               defaultScope,
+		httpClient:          defaultHTTPClient,
+		refreshBuffer:       defaultTokenRefreshBuffer,
+		streamRefreshBuffer: defaultStreamRefreshBuffer,
+		maxResponseBytes:    defaultMaxResponseBytes,
+		refreshInterval:     defaultTokenRefreshInterval,
+		refreshMaxAttempts:  1,
+		logger:              log.Default(),
+		userAgent:           defaultUserAgent,
+		metrics:             noopMetrics{},
+		wg:                  &sync.WaitGroup{},
 	}
 
-	ctxWithCancel, cancel := context.WithCancel(context.Background())
-	client.ctxCancel = cancel
-
 	for _, opt := range opts {
 		opt(client)
 	}
 
-	access, err := client.oauthCreate(ctx)
-	if err != nil {
+	if err := client.validateConfig(); err != nil {
+		return nil, err
+	}
+	if client.httpClient == defaultHTTPClient {
+		transport := client.transport()
+		client.tuneConnectionPool(transport)
+		// Streams are not bound by the client's Timeout; bound the wait for
+		// their response headers by it instead.
+		transport.ResponseHeaderTimeout = client.httpClient.Timeout
+	}
+	streamHTTPClient := *client.httpClient
+	streamHTTPClient.Timeout = 0
+	client.streamHTTPClient = &streamHTTPClient
+
+	ctxWithCancel, cancel := context.WithCancel(context.Background())
+	client.ctxCancel = cancel
+
+	access, err := client.initialToken(ctx)
+	switch {
+	case err != nil && client.lenientStart:
+		client.logf("gigago: token fetch failed, retrying on first use: %v", err)
+	case err != nil:
+		cancel()
 		return nil, fmt.Errorf("token fetch failed: %w", err)
 	}
 
 	client.accessToken = access
 
-	client.wg.Add(1)
-	go client.tokenRefresher(ctxWithCancel)
+	if !client.noBackgroundRefresh {
+		client.wg.Add(1)
+		go client.tokenRefresher(ctxWithCancel)
+	}
+	// The callback must not call Close: it may run before stopCloseOnDone is assigned.
+	client.stopCloseOnDone = context.AfterFunc(ctx, client.close)
 
 	return client, nil
 }
 
+// validateConfig checks the configuration assembled from the options passed to NewClient.
+func (c *Client) validateConfig() error {
+	switch {
+	case len(c.credentials) > 0:
+		if err := c.validateCredentialPool(); err != nil {
+			return err
+		}
+	case strings.TrimSpace(c.apiKey) == "" && !c.certAuth:
+		return fmt.Errorf("%w: apiKey cannot be empty, pass an authorization key or use WithClientCertificate", ErrNoCredentials)
+	case c.apiKey != "" && c.certAuth:
+		return fmt.Errorf("apiKey and client certificate are mutually exclusive, pass an empty apiKey with WithClientCertificate")
+	}
+
+	if err := validateBaseURL(c.baseURLAI); err != nil {
+		return fmt.Errorf("invalid AI API URL: %w", err)
+	}
+	if err := validateBaseURL(c.baseURLOauth); err != nil {
+		return fmt.Errorf("invalid OAuth URL: %w", err)
+	}
+
+	if c.scope == "" {
+		return fmt.Errorf("scope cannot be empty")
+	}
+	if !c.skipScopeCheck && c.scope != ScopePersonal && c.scope != ScopeB2B && c.scope != ScopeCorp {
+		return fmt.Errorf("unknown scope %q, use WithUnverifiedScope to set it anyway", c.scope)
+	}
+
+	if c.responseValidator != nil && c.responseValidatorAttempts <= 0 {
+		return fmt.Errorf("response validator attempts must be positive, got %d", c.responseValidatorAttempts)
+	}
+
+	if c.refreshBuffer <= 0 {
+		return fmt.Errorf("token refresh buffer must be positive, got %s", c.refreshBuffer)
+	}
+	if c.maxConcurrentRequests < 0 {
+		return fmt.Errorf("max concurrent requests must not be negative, got %d", c.maxConcurrentRequests)
+	}
+	if c.maxIdleConnsPerHost < 0 {
+		return fmt.Errorf("max idle connections per host must not be negative, got %d", c.maxIdleConnsPerHost)
+	}
+	if c.idleConnTimeout < 0 {
+		return fmt.Errorf("idle connection timeout must not be negative, got %s", c.idleConnTimeout)
+	}
+	if c.maxResponseBytes <= 0 {
+		return fmt.Errorf("max response bytes must be positive, got %d", c.maxResponseBytes)
+	}
+	if c.streamRefreshBuffer < 0 {
+		return fmt.Errorf("stream refresh buffer must not be negative, got %s", c.streamRefreshBuffer)
+	}
+	if c.refreshInterval <= 0 {
+		return fmt.Errorf("token refresh interval must be positive, got %s", c.refreshInterval)
+	}
+
+	if c.refreshMaxAttempts < 1 {
+		return fmt.Errorf("token refresh attempts must be at least 1, got %d", c.refreshMaxAttempts)
+	}
+	if c.refreshBaseDelay < 0 {
+		return fmt.Errorf("token refresh retry delay cannot be negative, got %s", c.refreshBaseDelay)
+	}
+
+	if c.embeddingBatchSize < 0 {
+		return fmt.Errorf("embeddings batch size cannot be negative, got %d", c.embeddingBatchSize)
+	}
+	if c.embeddingConcurrency < 0 {
+		return fmt.Errorf("embeddings concurrency cannot be negative, got %d", c.embeddingConcurrency)
+	}
+	for model, limit := range c.modelContextLimits {
+		if limit <= 0 {
+			return fmt.Errorf("context limit for model %q must be positive, got %d", model, limit)
+		}
+	}
+	return nil
+}
+
+// validateBaseURL checks that rawURL is an absolute http or https URL.
+func validateBaseURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use the http or https scheme", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
+
 // Close gracefully shuts down the client. It closes idle HTTP connections
-// and stops the background token refresher goroutine. It's recommended to
+// and stops the background token refresher goroutine, aborting a refresh in
+// progress instead of waiting for it to complete. It's recommended to
 // call Close when the client is no longer needed to prevent resource leaks.
-func (c *Client) Close() {
-	c.ctxCancel()
-	c.wg.Wait()
-	c.httpClient.CloseIdleConnections()
+//
+// After Close returns, API calls fail with ErrClientClosed.
+// Calling Close more than once is safe; subsequent calls are no-ops.
+// The client is also closed when the context passed to NewClient is done.
+func (c *Client) Close() error {
+	if c.stopCloseOnDone != nil {
+		c.stopCloseOnDone()
+	}
+	c.close()
+	return nil
+}
+
+// close shuts the client down once. Unlike Close, it leaves stopCloseOnDone alone,
+// so it is safe to run from the NewClient context callback.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		c.ctxCancel()
+		c.wg.Wait()
+		c.httpClient.CloseIdleConnections()
+	})
 }
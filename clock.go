@@ -0,0 +1,58 @@
+package gigago
+
+import "time"
+
+// Clock is the source of time for token expiration checks and the background
+// refresher. It exists so that tests can control time; see WithClock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a Timer that fires on its channel after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single-shot timer created by a Clock, like *time.Timer.
+type Timer interface {
+	// C returns the channel the current time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Reset changes the timer to fire after d.
+	Reset(d time.Duration) bool
+	// Stop prevents the timer from firing.
+	Stop() bool
+}
+
+// WithClock provides an Option to replace the system clock used for token
+// expiration checks, refresh scheduling and refresh retry backoff. It is meant
+// for tests that need to advance time deterministically.
+func WithClock(clock Clock) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// systemClock is the Clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// now returns the current time of the client's clock.
+func (c *Client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// newTimer returns a timer of the client's clock firing after d.
+func (c *Client) newTimer(d time.Duration) Timer {
+	if c.clock == nil {
+		return systemClock{}.NewTimer(d)
+	}
+	return c.clock.NewTimer(d)
+}
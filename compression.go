@@ -0,0 +1,60 @@
+package gigago
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WithCompression provides an Option to request gzip-compressed responses and
+// decompress them transparently, which saves bandwidth on large responses such as
+// embeddings. The client sets Accept-Encoding: gzip itself and decodes responses
+// with Content-Encoding: gzip, so it works with any transport, including ones that
+// disable Go's built-in compression. The size limit set with WithMaxResponseBytes
+// applies to the decompressed body.
+func WithCompression() Option {
+	return func(c *Client) {
+		c.compression = true
+	}
+}
+
+// decompress replaces a gzip-encoded response body with a decoding reader. The
+// encoding headers are removed, since they no longer describe the body.
+func decompress(resp *http.Response) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return
+	}
+
+	resp.Body = &gzipBody{body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// gzipBody decompresses a gzip-encoded body. The gzip reader is created on the
+// first Read, so that an invalid header is reported as a read error.
+// Close closes both the gzip reader and the underlying body.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	if b.zr != nil {
+		b.zr.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,149 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Conversation keeps the message history of a chat and bounds it to fit the
+// model's context window. The zero value is an empty conversation ready to use.
+// A Conversation is not safe for concurrent use.
+//
+// A typical loop appends the user's message, sends the trimmed history with Chat
+// and appends the answer:
+//
+//	conv.Append(gigago.Message{Role: gigago.RoleUser, Content: question})
+//	conv.TrimToTokens(4000, estimate)
+//	resp, err := client.Chat(ctx, &gigago.ChatRequest{Model: "GigaChat", Messages: conv.Messages()})
+//	...
+//	conv.Append(resp.Choices[0].Message.Message())
+type Conversation struct {
+	messages []Message
+}
+
+// NewConversation returns a Conversation starting with messages, usually a single
+// system message.
+func NewConversation(messages ...Message) *Conversation {
+	return &Conversation{messages: slices.Clone(messages)}
+}
+
+// Append adds msg to the end of the conversation.
+func (c *Conversation) Append(msg Message) {
+	c.messages = append(c.messages, msg)
+}
+
+// AddFunctionResult appends the result of the function called by the model as a
+// RoleFunction message. content is the function's result, usually encoded as JSON.
+// The assistant message with the call must be appended before it.
+func (c *Conversation) AddFunctionResult(name, content string) {
+	c.Append(Message{Role: RoleFunction, Name: name, Content: content})
+}
+
+// Messages returns a copy of the conversation's messages, oldest first.
+func (c *Conversation) Messages() []Message {
+	return slices.Clone(c.messages)
+}
+
+// TrimToTokens evicts the oldest messages until counter reports that the rest takes
+// up at most maxTokens tokens. System messages are never evicted, so the conversation
+// can still exceed maxTokens if they alone do. An assistant message with a function
+// call is evicted together with the function results that follow it.
+//
+// counter estimates the tokens of the messages passed to it, e.g. by summing the
+// counts returned by Client.CountTokens for their contents. It is called after each
+// eviction, so a cheap estimate is preferable.
+func (c *Conversation) TrimToTokens(maxTokens int, counter func([]Message) int) {
+	for counter(c.messages) > maxTokens {
+		i := slices.IndexFunc(c.messages, func(msg Message) bool { return msg.Role != RoleSystem })
+		if i < 0 {
+			return
+		}
+		c.messages = slices.Delete(c.messages, i, evictionEnd(c.messages, i))
+	}
+}
+
+// evictionEnd returns the end of the messages evicted together with messages[i]:
+// an assistant message with a function call takes the function results following
+// it along, so the history never starts with an orphaned result.
+func evictionEnd(messages []Message, i int) int {
+	end := i + 1
+	if messages[i].FunctionCall != nil {
+		for end < len(messages) && messages[end].Role == RoleFunction {
+			end++
+		}
+	}
+	return end
+}
+
+// WithAutoTrim provides a RequestOption for Chat to evict the oldest messages of
+// the request until the rest takes up at most maxTokens tokens, e.g. to keep a
+// long chat within the model's context window. The tokens of each message are
+// counted with a single CountTokens call, which costs an extra request.
+//
+// System messages, the most recent user message and the messages after it, e.g.
+// a function call and its result, are never evicted. An assistant message with a
+// function call is evicted together with the function results that follow it,
+// so the history never starts with an orphaned result. If the kept messages exceed
+// maxTokens, Chat fails with ErrContextTooLong without sending the request.
+// The request itself is not modified.
+func WithAutoTrim(maxTokens int) RequestOption {
+	return func(o *requestOptions) {
+		o.autoTrim = maxTokens
+	}
+}
+
+// trimToTokens returns req with its oldest messages evicted as described by
+// WithAutoTrim, or req itself if it already fits.
+func (c *Client) trimToTokens(ctx context.Context, req *ChatRequest, maxTokens int, opts []RequestOption) (*ChatRequest, error) {
+	input := make([]string, len(req.Messages))
+	for i, msg := range req.Messages {
+		input[i] = msg.Content
+	}
+	counts, err := c.CountTokens(ctx, req.Model, input, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count prompt tokens: %w", err)
+	}
+	if len(counts) != len(req.Messages) {
+		return nil, fmt.Errorf("failed to count prompt tokens: got %d counts for %d messages", len(counts), len(req.Messages))
+	}
+
+	var tokens int
+	for _, count := range counts {
+		tokens += count.Tokens
+	}
+	if tokens <= maxTokens {
+		return req, nil
+	}
+
+	// The most recent user message and the messages after it are kept.
+	keepFrom := len(req.Messages)
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			keepFrom = i
+			break
+		}
+	}
+
+	messages := make([]Message, 0, len(req.Messages))
+	for i := 0; i < len(req.Messages); {
+		msg := req.Messages[i]
+		if tokens > maxTokens && i < keepFrom && msg.Role != RoleSystem {
+			end := evictionEnd(req.Messages, i)
+			for _, count := range counts[i:end] {
+				tokens -= count.Tokens
+			}
+			i = end
+			continue
+		}
+		messages = append(messages, msg)
+		i++
+	}
+	if tokens > maxTokens {
+		return nil, fmt.Errorf("%w: %d tokens left after trimming, limit is %d", ErrContextTooLong, tokens, maxTokens)
+	}
+
+	trimmed := *req
+	trimmed.Messages = messages
+	return &trimmed, nil
+}
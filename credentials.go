@@ -0,0 +1,87 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Credential is one of the authorization keys used by a client set up with
+// WithCredentialPool.
+type Credential struct {
+	// Name identifies the credential in logs and ActiveCredential, e.g. the name
+	// of the GigaChat project the key belongs to. It is optional.
+	Name string
+	// AuthKey is the authorization key, as passed to NewClient.
+	AuthKey string
+}
+
+// WithCredentialPool provides an Option to authenticate with several authorization
+// keys, e.g. of different GigaChat projects, and fail over between them. Access
+// tokens are requested with the active key, initially the first one. If the OAuth
+// endpoint rejects it (see AuthError.Permanent) or responds with 429 Too Many
+// Requests, the next key is tried, wrapping around, and the first key that succeeds
+// becomes the active one. If every key fails, the error of the last one is returned.
+//
+// All keys are used with the client's scope. Pass an empty apiKey to NewClient when
+// using it; it is mutually exclusive with WithClientCertificate.
+func WithCredentialPool(keys []Credential) Option {
+	return func(c *Client) {
+		c.credentials = append([]Credential(nil), keys...)
+	}
+}
+
+// ActiveCredential returns the credential currently used to request access tokens,
+// for debugging failovers. It returns false if WithCredentialPool is not set.
+func (c *Client) ActiveCredential() (Credential, bool) {
+	if len(c.credentials) == 0 {
+		return Credential{}, false
+	}
+	return c.credentials[c.activeCredential.Load()], true
+}
+
+// validateCredentialPool checks the keys set with WithCredentialPool.
+func (c *Client) validateCredentialPool() error {
+	if c.apiKey != "" || c.certAuth {
+		return fmt.Errorf("credential pool is mutually exclusive with apiKey and client certificate, pass an empty apiKey")
+	}
+	for i, cred := range c.credentials {
+		if strings.TrimSpace(cred.AuthKey) == "" {
+			return fmt.Errorf("%w: credential %d (%s) has an empty AuthKey", ErrNoCredentials, i, cred.Name)
+		}
+	}
+	return nil
+}
+
+// oauthCreate requests a new access token, failing over between the keys of the
+// credential pool as described by WithCredentialPool.
+func (c *Client) oauthCreate(ctx context.Context) (*Token, error) {
+	if len(c.credentials) == 0 {
+		return c.oauthRequest(ctx, c.apiKey)
+	}
+
+	start := int(c.activeCredential.Load())
+	var err error
+	for i := range c.credentials {
+		index := (start + i) % len(c.credentials)
+		var token *Token
+		if token, err = c.oauthRequest(ctx, c.credentials[index].AuthKey); err == nil {
+			c.activeCredential.Store(int32(index))
+			return token, nil
+		}
+		if !shouldFailOver(err) {
+			return nil, err
+		}
+		c.logf("gigago: credential %d (%s) failed, trying the next one: %v", index, c.credentials[index].Name, err)
+	}
+	return nil, err
+}
+
+// shouldFailOver reports whether a failed token request should be retried with
+// another key of the credential pool.
+func shouldFailOver(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && (authErr.Permanent() || authErr.StatusCode == http.StatusTooManyRequests)
+}
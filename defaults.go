@@ -0,0 +1,85 @@
+package gigago
+
+// WithModelDefaults provides an Option to set default parameters for requests to
+// model sent with Chat or ChatStream. Fields of a request that are unset are filled
+// from defaults, so the precedence is: the value set on the request, then the
+// model default, then the server default.
+//
+// A field counts as unset if it holds its zero value: nil for the pointer fields
+// (Temperature, TopP, RepetitionPenalty, N, ProfanityCheck, UpdateInterval), the
+// slice Functions and the map Extra, zero for MaxTokens and FunctionCall. Because
+// of that, a request cannot override a default MaxTokens with zero.
+// Extra is taken as a whole, not merged key by key. Model, Messages and Stream of
+// defaults are ignored. Calling it again for the same model replaces its defaults.
+func WithModelDefaults(model string, defaults ChatRequest) Option {
+	return func(c *Client) {
+		if c.modelDefaults == nil {
+			c.modelDefaults = make(map[string]ChatRequest)
+		}
+		c.modelDefaults[model] = defaults
+	}
+}
+
+// WithDefaultModel provides an Option to set the model of Chat and ChatStream
+// requests that leave ChatRequest.Model empty. A model set on the request takes
+// precedence. Defaults set with WithModelDefaults for the default model apply to
+// such requests as well. Without it, requests with no model fail validation.
+func WithDefaultModel(model string) Option {
+	return func(c *Client) {
+		c.defaultModel = model
+	}
+}
+
+// withDefaultModel returns req with its model set to the default model if it
+// has none. req itself is never modified.
+func (c *Client) withDefaultModel(req *ChatRequest) *ChatRequest {
+	if req == nil || req.Model != "" || c.defaultModel == "" {
+		return req
+	}
+	withModel := *req
+	withModel.Model = c.defaultModel
+	return &withModel
+}
+
+// applyModelDefaults returns req with its unset fields filled from the defaults of
+// its model. req itself is never modified; if there are no defaults, it is
+// returned as is.
+func (c *Client) applyModelDefaults(req *ChatRequest) *ChatRequest {
+	defaults, ok := c.modelDefaults[req.Model]
+	if !ok {
+		return req
+	}
+
+	merged := *req
+	if merged.Temperature == nil {
+		merged.Temperature = defaults.Temperature
+	}
+	if merged.MaxTokens == 0 {
+		merged.MaxTokens = defaults.MaxTokens
+	}
+	if merged.TopP == nil {
+		merged.TopP = defaults.TopP
+	}
+	if merged.RepetitionPenalty == nil {
+		merged.RepetitionPenalty = defaults.RepetitionPenalty
+	}
+	if merged.N == nil {
+		merged.N = defaults.N
+	}
+	if merged.ProfanityCheck == nil {
+		merged.ProfanityCheck = defaults.ProfanityCheck
+	}
+	if merged.UpdateInterval == nil {
+		merged.UpdateInterval = defaults.UpdateInterval
+	}
+	if merged.Extra == nil {
+		merged.Extra = defaults.Extra
+	}
+	if merged.Functions == nil {
+		merged.Functions = defaults.Functions
+	}
+	if merged.FunctionCall == (FunctionCallMode{}) {
+		merged.FunctionCall = defaults.FunctionCall
+	}
+	return &merged
+}
@@ -0,0 +1,259 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultQueueBatchSize is the default number of texts an EmbeddingQueue sends per request.
+	defaultQueueBatchSize = 100
+	// defaultQueueMaxWait is the default time an EmbeddingQueue waits for a batch to fill up.
+	defaultQueueMaxWait = 50 * time.Millisecond
+)
+
+// EmbeddingResult is the outcome of a text submitted to an EmbeddingQueue.
+type EmbeddingResult struct {
+	// Embedding is the embedding vector of the text, if Err is nil.
+	Embedding []float32
+	// Err is the error of the batch the text was sent in, or of its context.
+	Err error
+}
+
+// EmbeddingQueue collects texts submitted one by one, e.g. by an indexing
+// pipeline, and embeds them in batches: a batch is sent once it holds the batch
+// size (see WithQueueBatchSize) or the oldest text in it has waited for the
+// maximum wait time (see WithQueueMaxWait). Batches are sent concurrently, within
+// the limits configured on the client.
+//
+// An EmbeddingQueue is safe for concurrent use. Call Shutdown when done with it to
+// send the texts still waiting and release its goroutine, or Close to drop them.
+type EmbeddingQueue struct {
+	c         *Client
+	model     string
+	batchSize int
+	maxWait   time.Duration
+	opts      []RequestOption
+
+	items     chan queuedText
+	done      chan struct{}
+	closeOnce sync.Once
+	// ctx bounds the batches being sent; cancel aborts them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg tracks the collecting goroutine and the batches being sent.
+	wg sync.WaitGroup
+}
+
+// queuedText is a text waiting in an EmbeddingQueue.
+type queuedText struct {
+	ctx    context.Context
+	text   string
+	result chan EmbeddingResult
+}
+
+// EmbeddingQueueOption configures an EmbeddingQueue.
+type EmbeddingQueueOption func(*EmbeddingQueue)
+
+// WithQueueBatchSize provides an EmbeddingQueueOption to send batches of up to
+// n texts. Defaults to 100.
+func WithQueueBatchSize(n int) EmbeddingQueueOption {
+	return func(q *EmbeddingQueue) {
+		q.batchSize = n
+	}
+}
+
+// WithQueueMaxWait provides an EmbeddingQueueOption to send a batch that is not
+// full once its first text has waited for d. Defaults to 50ms.
+func WithQueueMaxWait(d time.Duration) EmbeddingQueueOption {
+	return func(q *EmbeddingQueue) {
+		q.maxWait = d
+	}
+}
+
+// WithQueueRequestOptions provides an EmbeddingQueueOption to pass opts to
+// every Embeddings call made by the queue.
+func WithQueueRequestOptions(opts ...RequestOption) EmbeddingQueueOption {
+	return func(q *EmbeddingQueue) {
+		q.opts = opts
+	}
+}
+
+// NewEmbeddingQueue returns an EmbeddingQueue embedding texts with the given model.
+// It returns an error if the model is empty or an option is out of range.
+func (c *Client) NewEmbeddingQueue(model string, opts ...EmbeddingQueueOption) (*EmbeddingQueue, error) {
+	if model == "" {
+		return nil, fmt.Errorf("model cannot be empty")
+	}
+
+	q := &EmbeddingQueue{
+		c:         c,
+		model:     model,
+		batchSize: defaultQueueBatchSize,
+		maxWait:   defaultQueueMaxWait,
+		items:     make(chan queuedText),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.batchSize <= 0 {
+		return nil, fmt.Errorf("queue batch size must be positive, got %d", q.batchSize)
+	}
+	if q.maxWait <= 0 {
+		return nil, fmt.Errorf("queue max wait must be positive, got %s", q.maxWait)
+	}
+
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.wg.Add(1)
+	go q.collect()
+	return q, nil
+}
+
+// Submit adds text to the next batch and returns a channel that receives its
+// result once the batch has been sent. The channel is buffered, so the result
+// need not be read. If ctx is done before the batch is sent, the result holds
+// ctx.Err() instead; a batch in flight is not aborted. After Shutdown or Close,
+// the result holds ErrEmbeddingQueueClosed.
+func (q *EmbeddingQueue) Submit(ctx context.Context, text string) <-chan EmbeddingResult {
+	result := make(chan EmbeddingResult, 1)
+	select {
+	case q.items <- queuedText{ctx: ctx, text: text, result: result}:
+	case <-ctx.Done():
+		result <- EmbeddingResult{Err: ctx.Err()}
+	case <-q.done:
+		result <- EmbeddingResult{Err: ErrEmbeddingQueueClosed}
+	}
+	return result
+}
+
+// Shutdown stops the queue, sends the texts still waiting and waits until all
+// batches have been sent. If ctx is done first, the batches in flight are aborted,
+// their texts fail with ErrEmbeddingQueueClosed, and Shutdown returns ctx.Err()
+// once they have returned. Later calls to Submit fail with ErrEmbeddingQueueClosed.
+func (q *EmbeddingQueue) Shutdown(ctx context.Context) error {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// Close stops the queue at once: the texts still waiting and the batches in
+// flight fail with ErrEmbeddingQueueClosed. Use Shutdown to send them first.
+// Close returns no error and may be called more than once.
+func (q *EmbeddingQueue) Close() error {
+	q.cancel()
+	return q.Shutdown(context.Background())
+}
+
+// collect gathers submitted texts into batches and sends them, until Close.
+func (q *EmbeddingQueue) collect() {
+	defer q.wg.Done()
+
+	var (
+		pending []queuedText
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(pending) == 0 {
+			return
+		}
+		batch := pending
+		pending = nil
+		q.wg.Add(1)
+		go q.send(batch)
+	}
+
+	for {
+		select {
+		case item := <-q.items:
+			pending = append(pending, item)
+			if len(pending) >= q.batchSize {
+				flush()
+			} else if timer == nil {
+				timer = time.NewTimer(q.maxWait)
+				timeout = timer.C
+			}
+		case <-timeout:
+			flush()
+		case <-q.done:
+			flush()
+			return
+		}
+	}
+}
+
+// send embeds a batch of texts and delivers the results. Texts whose context is
+// already done are left out of the request. The request is aborted if the queue
+// is closed.
+func (q *EmbeddingQueue) send(batch []queuedText) {
+	defer q.wg.Done()
+
+	if q.ctx.Err() != nil {
+		for _, item := range batch {
+			item.result <- EmbeddingResult{Err: ErrEmbeddingQueueClosed}
+		}
+		return
+	}
+	live := batch[:0]
+	for _, item := range batch {
+		if err := item.ctx.Err(); err != nil {
+			item.result <- EmbeddingResult{Err: err}
+			continue
+		}
+		live = append(live, item)
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	input := make([]string, len(live))
+	for i, item := range live {
+		input[i] = item.text
+	}
+	resp, err := q.c.Embeddings(q.ctx, &EmbeddingsRequest{Model: q.model, Input: input}, q.opts...)
+	if err != nil && q.ctx.Err() != nil {
+		err = ErrEmbeddingQueueClosed
+	}
+	if err != nil {
+		for _, item := range live {
+			item.result <- EmbeddingResult{Err: err}
+		}
+		return
+	}
+
+	vectors := make([][]float32, len(live))
+	for _, embedding := range resp.Data {
+		if embedding.Index >= 0 && embedding.Index < len(vectors) {
+			vectors[embedding.Index] = embedding.Embedding
+		}
+	}
+	for i, item := range live {
+		if vectors[i] == nil {
+			item.result <- EmbeddingResult{Err: fmt.Errorf("response contains no embedding for input %d", i)}
+			continue
+		}
+		item.result <- EmbeddingResult{Embedding: vectors[i]}
+	}
+}
@@ -0,0 +1,236 @@
+package gigago
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const embeddingsPath = "/embeddings"
+
+// EmbeddingsRequest is a request to compute vector embeddings for a batch of texts.
+type EmbeddingsRequest struct {
+	// Model is the name of the embeddings model, e.g. "Embeddings" or "EmbeddingsGigaR".
+	Model string `json:"model"`
+
+	// Input is the list of texts to embed.
+	Input []string `json:"input"`
+}
+
+// EmbeddingsResponse is the response from the embeddings endpoint.
+type EmbeddingsResponse struct {
+	// Data holds one embedding per input text.
+	Data []Embedding `json:"data"`
+
+	// Model specifies the model used to compute the embeddings.
+	Model string `json:"model"`
+
+	// Object is the type of the API object, typically "list".
+	Object string `json:"object"`
+
+	// RequestID is the ID the API assigned to the request (the X-Request-ID
+	// response header), if any. With batching enabled, it is the ID of the
+	// first batch.
+	RequestID string `json:"-"`
+}
+
+func (r *EmbeddingsResponse) setRequestID(id string) { r.RequestID = id }
+
+// Dimensions returns the dimension of the embedding vectors, e.g. to set up a
+// vector store, or 0 if the response holds no embeddings. Embeddings makes sure
+// that all vectors of a response have the same dimension.
+func (r *EmbeddingsResponse) Dimensions() int {
+	if len(r.Data) == 0 {
+		return 0
+	}
+	return len(r.Data[0].Embedding)
+}
+
+// checkDimensions returns an error if the embedding vectors of r differ in length.
+func (r *EmbeddingsResponse) checkDimensions() error {
+	dimensions := r.Dimensions()
+	for _, embedding := range r.Data {
+		if len(embedding.Embedding) != dimensions {
+			return fmt.Errorf("embedding %d has %d dimensions, want %d as the others", embedding.Index, len(embedding.Embedding), dimensions)
+		}
+	}
+	return nil
+}
+
+// Embedding is the vector representation of a single input text.
+type Embedding struct {
+	// Embedding is the embedding vector.
+	Embedding []float32 `json:"embedding"`
+
+	// Index is the position of the corresponding text in EmbeddingsRequest.Input.
+	Index int `json:"index"`
+
+	// Object is the type of the API object, typically "embedding".
+	Object string `json:"object"`
+
+	// Usage provides statistics on token consumption for this input.
+	Usage EmbeddingUsage `json:"usage"`
+}
+
+// EmbeddingUsage contains token usage statistics for a single embedded text.
+type EmbeddingUsage struct {
+	// PromptTokens is the number of tokens in the input text.
+	PromptTokens int `json:"prompt_tokens"`
+}
+
+// SimilarityTo returns the cosine similarity of e and other, see CosineSimilarity.
+func (e Embedding) SimilarityTo(other Embedding) (float64, error) {
+	return CosineSimilarity(e.Embedding, other.Embedding)
+}
+
+// CosineSimilarity returns the cosine of the angle between the vectors a and b,
+// from -1 for opposite to 1 for identical directions. It is computed in float64
+// to avoid losing precision on long vectors. It returns an error if the vectors
+// have different lengths or either of them is empty or all zeros.
+func CosineSimilarity(a, b []float32) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("vector lengths differ: %d and %d", len(a), len(b))
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+	}
+	if normA == 0 || normB == 0 {
+		return 0, fmt.Errorf("cosine similarity is undefined for a zero vector")
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), nil
+}
+
+// WithEmbeddingBatchSize provides an Option to split Embeddings calls with more
+// than n input texts into requests of at most n texts each, for inputs exceeding
+// the per-request limit of the API. The results are merged in input order.
+// Batches are sent one after another unless WithEmbeddingConcurrency is set.
+// Defaults to 0, which sends all texts in a single request.
+func WithEmbeddingBatchSize(n int) Option {
+	return func(c *Client) {
+		c.embeddingBatchSize = n
+	}
+}
+
+// WithEmbeddingConcurrency provides an Option to send up to n embeddings batches
+// (see WithEmbeddingBatchSize) in parallel. Defaults to 1.
+func WithEmbeddingConcurrency(n int) Option {
+	return func(c *Client) {
+		c.embeddingConcurrency = n
+	}
+}
+
+// Embeddings computes vector embeddings for the texts in req.Input.
+// It uses the same authentication and retry behavior as Chat.
+// Large inputs are split into batches if WithEmbeddingBatchSize is set; every
+// Embedding.Index still refers to the position in req.Input, and the first failed
+// batch fails the whole call. Non-2xx responses are returned as *APIError, and
+// responses with vectors of different dimensions as an error.
+func (c *Client) Embeddings(ctx context.Context, req *EmbeddingsRequest, opts ...RequestOption) (*EmbeddingsResponse, error) {
+	if req == nil || len(req.Input) == 0 {
+		return nil, fmt.Errorf("empty input")
+	}
+	if req.Model == "" {
+		return nil, fmt.Errorf("model cannot be empty")
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx, span := c.startSpan(ctx, "gigago.Embeddings", req.Model)
+	var (
+		resp *EmbeddingsResponse
+		err  error
+	)
+	if c.embeddingBatchSize > 0 && len(req.Input) > c.embeddingBatchSize {
+		resp, err = c.embedBatches(ctx, req, opts)
+	} else {
+		resp = &EmbeddingsResponse{}
+		err = c.doRequest(ctx, http.MethodPost, embeddingsPath, req, resp, opts)
+	}
+	if err == nil {
+		err = resp.checkDimensions()
+	}
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// embedBatches sends req.Input in batches of c.embeddingBatchSize texts, at most
+// c.embeddingConcurrency at a time, and merges the results in input order.
+// The first failed batch cancels the batches still running.
+func (c *Client) embedBatches(ctx context.Context, req *EmbeddingsRequest, opts []RequestOption) (*EmbeddingsResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := slices.Collect(slices.Chunk(req.Input, c.embeddingBatchSize))
+	results := make([]EmbeddingsResponse, len(batches))
+
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+	fail := func(i int, err error) {
+		failOnce.Do(func() {
+			firstErr = fmt.Errorf("embeddings batch %d: %w", i, err)
+			cancel()
+		})
+	}
+
+	sem := make(chan struct{}, max(c.embeddingConcurrency, 1))
+	for i, batch := range batches {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			// The batches from i on are never sent.
+			fail(i, err)
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			batchReq := &EmbeddingsRequest{Model: req.Model, Input: batch}
+			if err := c.doRequest(ctx, http.MethodPost, embeddingsPath, batchReq, &results[i], opts); err != nil {
+				fail(i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	resp := &EmbeddingsResponse{Model: results[0].Model, Object: results[0].Object, RequestID: results[0].RequestID}
+	for i, result := range results {
+		for _, embedding := range result.Data {
+			embedding.Index += i * c.embeddingBatchSize
+			resp.Data = append(resp.Data, embedding)
+		}
+	}
+	if len(resp.Data) != len(req.Input) {
+		return nil, fmt.Errorf("embeddings response contains %d embeddings for %d inputs", len(resp.Data), len(req.Input))
+	}
+	slices.SortStableFunc(resp.Data, func(a, b Embedding) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return resp, nil
+}
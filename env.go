@@ -0,0 +1,39 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Environment variables read by NewClientFromEnv.
+const (
+	// EnvAuthKey holds the authorization key. It is required.
+	EnvAuthKey = "GIGACHAT_AUTH_KEY"
+	// EnvScope holds the API scope, e.g. GIGACHAT_API_PERS. It is optional.
+	EnvScope = "GIGACHAT_SCOPE"
+	// EnvBaseURL holds the base URL of the GigaChat API. It is optional.
+	EnvBaseURL = "GIGACHAT_BASE_URL"
+)
+
+// NewClientFromEnv is like NewClient, but takes the authorization key from the
+// GIGACHAT_AUTH_KEY environment variable, and the scope and base URL of the API
+// from GIGACHAT_SCOPE and GIGACHAT_BASE_URL if they are set. It returns an error
+// naming the variable if GIGACHAT_AUTH_KEY is missing. opts are applied after the
+// settings from the environment, so they take precedence.
+func NewClientFromEnv(ctx context.Context, opts ...Option) (*Client, error) {
+	apiKey := os.Getenv(EnvAuthKey)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: environment variable %s is not set", ErrNoCredentials, EnvAuthKey)
+	}
+
+	var envOpts []Option
+	if scope := os.Getenv(EnvScope); scope != "" {
+		envOpts = append(envOpts, WithCustomScope(scope))
+	}
+	if baseURL := os.Getenv(EnvBaseURL); baseURL != "" {
+		envOpts = append(envOpts, WithAPIBaseURL(baseURL))
+	}
+
+	return NewClient(ctx, apiKey, append(envOpts, opts...)...)
+}
@@ -0,0 +1,190 @@
+package gigago
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrClientClosed is returned by API calls made after the Client has been closed.
+var ErrClientClosed = errors.New("gigago: client is closed")
+
+// ErrNoCredentials is returned by NewClient if neither an authorization key
+// nor a client certificate (see WithClientCertificate) is configured.
+var ErrNoCredentials = errors.New("gigago: no credentials configured")
+
+// ErrNoChoices is returned when a response that should carry a completion has no choices.
+var ErrNoChoices = errors.New("gigago: response contains no choices")
+
+// ErrContextTooLong is returned by Chat if the prompt exceeds the limit set for
+// the model with WithModelContextLimit, or the messages that WithAutoTrim keeps
+// exceed its budget. The request is not sent.
+var ErrContextTooLong = errors.New("gigago: prompt exceeds the model context limit")
+
+// ErrResponseTooLarge is returned when reading a response body beyond the limit
+// set with WithMaxResponseBytes.
+var ErrResponseTooLarge = errors.New("gigago: response body too large")
+
+// ErrStreamClosed is returned by ChatStream.Recv after the stream has been closed with Close.
+var ErrStreamClosed = errors.New("gigago: stream is closed")
+
+// ErrEmbeddingQueueClosed is the result of texts submitted to an EmbeddingQueue
+// after Shutdown or Close, and of texts dropped by Close.
+var ErrEmbeddingQueueClosed = errors.New("gigago: embedding queue is closed")
+
+// AuthError is returned when the OAuth endpoint rejects a token request.
+// Use errors.As to inspect the status code and decide whether retrying makes sense.
+type AuthError struct {
+	// StatusCode is the HTTP status code returned by the OAuth endpoint.
+	StatusCode int
+	// Body is the raw response body returned by the OAuth endpoint.
+	Body []byte
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("oauth request failed with status %d: %s", e.StatusCode, string(e.Body))
+}
+
+// Permanent reports whether the error means the credentials themselves were rejected
+// (HTTP 401 or 403). Retrying such a request without changing credentials will not succeed.
+func (e *AuthError) Permanent() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// ValidationError is returned when a request is rejected by the client before
+// it is sent, e.g. by ChatRequest.Validate.
+type ValidationError struct {
+	// Index is the index of the offending message in ChatRequest.Messages,
+	// or -1 if the error is not about a single message.
+	Index int
+	// Field is the JSON name of the offending field, e.g. "role" or "model".
+	Field string
+	// Message describes what is wrong with the field.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("invalid chat request: messages[%d].%s: %s", e.Index, e.Field, e.Message)
+	}
+	return fmt.Sprintf("invalid chat request: %s: %s", e.Field, e.Message)
+}
+
+// APIError is returned when the GigaChat API responds with a non-2xx status code.
+// Code and Message are filled in when the body is a GigaChat error object;
+// the raw body is always kept in Body for error shapes the client doesn't know.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the GigaChat error code, if the response carried one.
+	Code string
+	// Message is the human-readable error message, if the response carried one.
+	Message string
+	// Body is the raw response body.
+	Body []byte
+	// RequestID is the ID the API assigned to the request (the X-Request-ID
+	// response header), if any. Include it when contacting GigaChat support.
+	RequestID string
+	// ContentType is the Content-Type of the response, if any. A type other than
+	// JSON, e.g. text/html, usually means the error comes from a proxy or gateway
+	// rather than from GigaChat.
+	ContentType string
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Message == "" && checkJSONContentType(e.ContentType) != nil:
+		return fmt.Sprintf("unexpected status %d with %s response, likely not from GigaChat: %s", e.StatusCode, e.ContentType, string(e.Body))
+	case e.Message == "":
+		return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, string(e.Body))
+	case e.Code != "":
+		return fmt.Sprintf("unexpected status %d: %s (code %s)", e.StatusCode, e.Message, e.Code)
+	default:
+		return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Message)
+	}
+}
+
+// IsRateLimited reports whether the request was rejected with 429 Too Many Requests.
+func (e *APIError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsUnauthorized reports whether the request was rejected with 401 Unauthorized.
+func (e *APIError) IsUnauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized
+}
+
+// maxDecodeErrorSnippet is how much of an undecodable body ResponseDecodeError.Error shows.
+const maxDecodeErrorSnippet = 256
+
+// ResponseDecodeError is returned when a successful response carries a body that
+// cannot be decoded, e.g. JSON cut short by a dropped connection or an HTML page
+// served by a proxy (see ContentType). The error message
+// includes the start of the body; the whole body is kept in Body.
+type ResponseDecodeError struct {
+	// Endpoint is the URL path of the request, e.g. "/api/v1/chat/completions".
+	Endpoint string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// ContentType is the Content-Type of the response, if any.
+	ContentType string
+	// Body is the raw response body.
+	Body []byte
+	// Err is the underlying decoding error, or the error rejecting ContentType.
+	Err error
+}
+
+func (e *ResponseDecodeError) Error() string {
+	snippet := e.Body
+	truncated := ""
+	if len(snippet) > maxDecodeErrorSnippet {
+		snippet, truncated = snippet[:maxDecodeErrorSnippet], " (truncated)"
+	}
+	return fmt.Sprintf("failed to decode response from %s (status %d): %v: body %q%s", e.Endpoint, e.StatusCode, e.Err, snippet, truncated)
+}
+
+func (e *ResponseDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newResponseDecodeError builds a ResponseDecodeError for body, read from resp.
+func newResponseDecodeError(resp *http.Response, body []byte, err error) *ResponseDecodeError {
+	decodeErr := &ResponseDecodeError{StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: body, Err: err}
+	if resp.Request != nil {
+		decodeErr.Endpoint = resp.Request.URL.Path
+	}
+	return decodeErr
+}
+
+// newAPIError builds an APIError from a non-2xx response, reading its body.
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{
+		StatusCode:  resp.StatusCode,
+		Body:        body,
+		RequestID:   resp.Header.Get(requestIDHeader),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	var errBody struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+	}
+	if json.Unmarshal(body, &errBody) == nil {
+		apiErr.Message = errBody.Message
+		// GigaChat sends numeric codes, but accept strings as well.
+		if code := strings.Trim(string(errBody.Code), `"`); code != "null" {
+			apiErr.Code = code
+		}
+	}
+	return apiErr
+}
+
+// isPermanentAuthError reports whether err wraps an AuthError that should not be retried.
+func isPermanentAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.Permanent()
+}
@@ -0,0 +1,196 @@
+package gigago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"regexp"
+)
+
+const filesPath = "/files"
+
+// FilePurposeGeneral is the purpose for files attached to chat messages.
+const FilePurposeGeneral = "general"
+
+// File describes a file stored in GigaChat.
+type File struct {
+	// ID is the file identifier to reference in Message.Attachments.
+	ID string `json:"id"`
+
+	// Object is the type of the API object, typically "file".
+	Object string `json:"object"`
+
+	// Bytes is the size of the file in bytes.
+	Bytes int64 `json:"bytes"`
+
+	// CreatedAt is the Unix timestamp (seconds) of when the file was uploaded.
+	CreatedAt int64 `json:"created_at"`
+
+	// Filename is the name of the file.
+	Filename string `json:"filename"`
+
+	// Purpose is the intended use of the file, e.g. "general".
+	Purpose string `json:"purpose"`
+
+	// AccessPolicy is "private" for uploaded files and "public" for generated ones.
+	AccessPolicy string `json:"access_policy,omitempty"`
+}
+
+// UploadFile uploads the contents of r as a file called name and returns its
+// description. Pass the returned File.ID in Message.Attachments to reference the
+// file in a chat request. purpose is usually FilePurposeGeneral.
+//
+// The content type is derived from the extension of name. The file is streamed
+// to the server without being buffered in memory, so the request cannot be
+// replayed: if the API responds with 401 Unauthorized, the token is refreshed
+// but the upload fails and has to be retried by the caller.
+func (c *Client) UploadFile(ctx context.Context, name string, r io.Reader, purpose string, opts ...RequestOption) (*File, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	if name == "" {
+		return nil, fmt.Errorf("file name cannot be empty")
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	// One boundary is shared by every attempt so the Content-Type header can be
+	// set upfront. The multipart form is written from its own goroutine; the
+	// transport closes the pipe once the request ends, which unblocks the writer.
+	boundary := multipart.NewWriter(nil).Boundary()
+	used := false
+	body := func() (io.Reader, error) {
+		if used {
+			return nil, errors.New("upload body cannot be replayed")
+		}
+		used = true
+
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+
+		go func() {
+			pw.CloseWithError(writeUpload(mw, name, r, purpose))
+		}()
+		return pr, nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+
+	ctx, span := c.startSpan(ctx, "gigago.UploadFile", "")
+	var file File
+	resp, err := c.send(ctx, http.MethodPost, filesPath, body, c.requestHeader(http.MethodPost, header, opts))
+	if err == nil {
+		err = decodeResponse(resp, &file)
+	}
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DownloadFile fetches the contents of the file with the given id, e.g. an image
+// generated by the model (see ExtractImageIDs). It returns the content and its
+// MIME type. The caller must close the returned reader; the timeout set by
+// WithRequestTimeout keeps running until then.
+func (c *Client) DownloadFile(ctx context.Context, id string, opts ...RequestOption) (io.ReadCloser, string, error) {
+	if c.closed.Load() {
+		return nil, "", ErrClientClosed
+	}
+	if id == "" {
+		return nil, "", fmt.Errorf("file id cannot be empty")
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+
+	header := http.Header{}
+	header.Set("Accept", "application/jpg")
+
+	ctx, span := c.startSpan(ctx, "gigago.DownloadFile", "")
+	resp, err := c.send(ctx, http.MethodGet, filesPath+"/"+url.PathEscape(id)+"/content", nil, c.requestHeader(http.MethodGet, header, opts))
+	if err != nil {
+		span.End(err)
+		cancel()
+		return nil, "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer cancel()
+		defer drainAndClose(resp.Body)
+		err := newAPIError(resp)
+		span.End(err)
+		return nil, "", err
+	}
+	span.End(nil)
+
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, resp.Header.Get("Content-Type"), nil
+}
+
+// imageSrcPattern matches the <img src="..."> tags GigaChat uses to reference generated images.
+var imageSrcPattern = regexp.MustCompile(`<img\s[^>]*?src="([^"]+)"`)
+
+// ExtractImageIDs returns the IDs of the images referenced in the messages of resp,
+// in order of appearance. Generated images are embedded as <img src="ID"> tags
+// in the message content; fetch them with Client.DownloadFile. GigaChat only
+// generates images when the request sets FunctionCall to FunctionCallAuto.
+func ExtractImageIDs(resp *ChatResponse) []string {
+	if resp == nil {
+		return nil
+	}
+
+	var ids []string
+	for _, choice := range resp.Choices {
+		for _, match := range imageSrcPattern.FindAllStringSubmatch(choice.Message.Content, -1) {
+			ids = append(ids, match[1])
+		}
+	}
+	return ids
+}
+
+// cancelOnClose releases a request context once the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// writeUpload writes the multipart form for UploadFile and closes the writer.
+func writeUpload(mw *multipart.Writer, name string, r io.Reader, purpose string) error {
+	fileType := mime.TypeByExtension(filepath.Ext(name))
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": name}))
+	partHeader.Set("Content-Type", fileType)
+
+	part, err := mw.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	if err := mw.WriteField("purpose", purpose); err != nil {
+		return err
+	}
+	return mw.Close()
+}
@@ -0,0 +1,84 @@
+package gigago
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Function calling modes for ChatRequest.FunctionCall.
+const (
+	// FunctionCallAuto lets the model decide whether to call one of the functions.
+	FunctionCallAuto = "auto"
+	// FunctionCallNone prevents the model from calling functions.
+	FunctionCallNone = "none"
+)
+
+// FunctionDef describes a function the model may call.
+type FunctionDef struct {
+	// Name is the function name the model uses to refer to it.
+	Name string `json:"name"`
+
+	// Description explains to the model what the function does and when to use it.
+	Description string `json:"description,omitempty"`
+
+	// Parameters is the JSON Schema of the function's arguments object.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// FunctionCallMode controls whether and which function the model calls.
+// The zero value leaves the choice to the server default.
+type FunctionCallMode struct {
+	// Mode is FunctionCallAuto or FunctionCallNone. It is ignored if Name is set.
+	Mode string
+
+	// Name forces the model to call the function with this name.
+	Name string
+}
+
+// MarshalJSON encodes the mode as GigaChat expects: a {"name": ...} object
+// when a specific function is forced, and a plain string otherwise.
+func (m FunctionCallMode) MarshalJSON() ([]byte, error) {
+	if m.Name != "" {
+		return json.Marshal(struct {
+			Name string `json:"name"`
+		}{Name: m.Name})
+	}
+	return json.Marshal(m.Mode)
+}
+
+// UnmarshalJSON decodes either form produced by MarshalJSON.
+func (m *FunctionCallMode) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*m = FunctionCallMode{Mode: mode}
+		return nil
+	}
+
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	*m = FunctionCallMode{Name: named.Name}
+	return nil
+}
+
+// UnmarshalArgs decodes the arguments of the call into v, usually a pointer to a
+// struct matching the function's Parameters schema. Arguments encoded as a JSON
+// string holding the object, as some streamed responses carry them, are accepted too.
+func (fc *FunctionCall) UnmarshalArgs(v any) error {
+	args := bytes.TrimSpace(fc.Arguments)
+	if len(args) > 0 && args[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(args, &encoded); err != nil {
+			return fmt.Errorf("failed to decode arguments of %s: %w", fc.Name, err)
+		}
+		args = []byte(encoded)
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("failed to decode arguments of %s: %w", fc.Name, err)
+	}
+	return nil
+}
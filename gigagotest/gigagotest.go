@@ -0,0 +1,117 @@
+// Package gigagotest provides helpers for testing code that uses gigago without
+// real credentials or network access.
+//
+//	client := gigagotest.NewTestClient(t, gigagotest.ChatResponse("Paris."))
+//	text, err := client.Complete(ctx, "GigaChat", "What is the capital of France?")
+package gigagotest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Role1776/gigago"
+)
+
+// AccessToken is the access token test clients send in the Authorization header.
+const AccessToken = "gigagotest-token"
+
+// RoundTripperFunc is an http.RoundTripper implemented by a function.
+// Use it with gigago.WithCustomClient to stub the transport entirely.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// NewTestClient returns a client whose API requests are served by handler from a
+// local test server. The client starts with a valid AccessToken, so no OAuth
+// request is made; token refreshes are answered by a second local server.
+// Both servers and the client are closed when the test ends.
+// The handler sees API paths such as "/chat/completions". opts are applied after
+// the test configuration.
+func NewTestClient(t testing.TB, handler http.Handler, opts ...gigago.Option) *gigago.Client {
+	t.Helper()
+
+	api := httptest.NewServer(handler)
+	t.Cleanup(api.Close)
+
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, newToken())
+	}))
+	t.Cleanup(oauth.Close)
+
+	opts = append([]gigago.Option{
+		gigago.WithAPIBaseURL(api.URL),
+		gigago.WithOAuthURL(oauth.URL),
+		gigago.WithTokenStore(staticTokenStore{}),
+	}, opts...)
+
+	client, err := gigago.NewClient(context.Background(), "gigagotest-key", opts...)
+	if err != nil {
+		t.Fatalf("gigagotest: failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// JSON returns a handler responding with status and v encoded as JSON.
+func JSON(status int, v any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, status, v)
+	}
+}
+
+// ChatResponse returns a handler responding to chat completion requests with a
+// single assistant message with the given content.
+func ChatResponse(content string) http.HandlerFunc {
+	return JSON(http.StatusOK, &gigago.ChatResponse{
+		Object: "chat.completion",
+		Model:  "GigaChat",
+		Choices: []gigago.Choice{{
+			Message:      gigago.ResponseMessage{Role: gigago.RoleAssistant, Content: content},
+			FinishReason: gigago.FinishReasonStop,
+		}},
+	})
+}
+
+// EmbeddingsResponse returns a handler responding to embeddings requests with
+// the given vectors, one per input text.
+func EmbeddingsResponse(vectors ...[]float32) http.HandlerFunc {
+	resp := &gigago.EmbeddingsResponse{Object: "list", Model: "Embeddings"}
+	for i, vector := range vectors {
+		resp.Data = append(resp.Data, gigago.Embedding{Object: "embedding", Embedding: vector, Index: i})
+	}
+	return JSON(http.StatusOK, resp)
+}
+
+// ErrorResponse returns a handler responding with status and a GigaChat error object.
+func ErrorResponse(status int, message string) http.HandlerFunc {
+	return JSON(status, map[string]any{"status": status, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newToken() *gigago.Token {
+	return &gigago.Token{AccessToken: AccessToken, ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}
+}
+
+// staticTokenStore hands out a fresh valid token and discards saved ones.
+type staticTokenStore struct{}
+
+func (staticTokenStore) Load(ctx context.Context) (*gigago.Token, error) {
+	return newToken(), nil
+}
+
+func (staticTokenStore) Save(ctx context.Context, token *gigago.Token) error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package gigagotest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Role1776/gigago"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTestClient(t *testing.T) {
+	var authorization string
+	client := NewTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		ChatResponse("Paris.")(w, r)
+	}))
+
+	text, err := client.Complete(t.Context(), "GigaChat", "What is the capital of France?")
+	require.NoError(t, err)
+	assert.Equal(t, "Paris.", text)
+	assert.Equal(t, "Bearer "+AccessToken, authorization)
+}
+
+func TestEmbeddingsResponse(t *testing.T) {
+	client := NewTestClient(t, EmbeddingsResponse([]float32{1, 2}, []float32{3, 4}))
+
+	resp, err := client.Embeddings(t.Context(), &gigago.EmbeddingsRequest{Model: "Embeddings", Input: []string{"a", "b"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 2)
+	assert.Equal(t, []float32{3, 4}, resp.Data[1].Embedding)
+	assert.Equal(t, 1, resp.Data[1].Index)
+}
+
+func TestErrorResponse(t *testing.T) {
+	client := NewTestClient(t, ErrorResponse(http.StatusNotFound, "No such model"))
+
+	_, err := client.Complete(t.Context(), "Unknown", "Hi")
+	var apiErr *gigago.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "No such model", apiErr.Message)
+}
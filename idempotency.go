@@ -0,0 +1,44 @@
+package gigago
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// WithIdempotencyHeader provides an Option to send an idempotency key in the given
+// header, e.g. "Idempotency-Key", with every POST request, for gateways in front of
+// GigaChat that use it to deduplicate retried requests. A new random key is
+// generated for each call and reused for all its retries, see WithRateLimitRetry.
+// Use WithIdempotencyKey to set the key of a single call. Defaults to no header.
+func WithIdempotencyHeader(name string) Option {
+	return func(c *Client) {
+		c.idempotencyHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+// WithIdempotencyKey provides a RequestOption to send key as the idempotency key of
+// the call instead of a generated one, e.g. to deduplicate a call repeated by the
+// application itself. It has no effect unless WithIdempotencyHeader is set.
+// The key is sent with every request the call makes, so do not use it with calls
+// that are split into several requests, e.g. by WithEmbeddingBatchSize.
+func WithIdempotencyKey(key string) RequestOption {
+	return func(o *requestOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// setIdempotencyKey sets the idempotency key header on header for a call with the
+// given method if WithIdempotencyHeader is set: key if it is not empty, otherwise
+// a new random key for POST requests.
+func (c *Client) setIdempotencyKey(header http.Header, method, key string) {
+	if c.idempotencyHeader == "" {
+		return
+	}
+	if key == "" && method == http.MethodPost {
+		key = uuid.NewString()
+	}
+	if key != "" {
+		header.Set(c.idempotencyHeader, key)
+	}
+}
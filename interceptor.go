@@ -0,0 +1,85 @@
+package gigago
+
+import (
+	"net/http"
+	"time"
+)
+
+// WithRequestInterceptor provides an Option to inspect or modify every outgoing
+// request, OAuth and API alike, right before it is sent and after all headers,
+// including Authorization, are set. It is useful for debugging and logging.
+// Calling it more than once adds interceptors, which run in order.
+//
+// The interceptor must not read req.Body, since that consumes the body being sent.
+// To inspect a JSON body, read a copy from req.GetBody, which is set for every
+// request except file uploads.
+func WithRequestInterceptor(interceptor func(req *http.Request)) Option {
+	return func(c *Client) {
+		c.requestInterceptors = append(c.requestInterceptors, interceptor)
+	}
+}
+
+// WithResponseInterceptor provides an Option to inspect every response received
+// for OAuth and API requests before the client processes it, including responses
+// that are retried (e.g. 401 or 429). Calling it more than once adds interceptors,
+// which run in order.
+//
+// An interceptor that reads resp.Body must replace it with a reader over the same
+// content, otherwise the client sees an empty body.
+func WithResponseInterceptor(interceptor func(resp *http.Response)) Option {
+	return func(c *Client) {
+		c.responseInterceptors = append(c.responseInterceptors, interceptor)
+	}
+}
+
+// do sends req with the client's HTTP client, running the configured request
+// interceptors before and the response interceptors after it. The request is
+// reported to the client's Metrics under endpoint and recorded on the span in
+// the request's context, whose trace context is injected into the headers.
+// The request is logged as set with WithRequestLogging. Requests accepting an
+// event stream are sent without the HTTP client's Timeout.
+// The response body is decompressed if WithCompression is set and limited as set
+// with WithMaxResponseBytes.
+func (c *Client) do(endpoint string, req *http.Request) (*http.Response, error) {
+	if c.tracer != nil {
+		c.tracer.Inject(req.Context(), req.Header)
+	}
+	if c.compression {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	for _, interceptor := range c.requestInterceptors {
+		interceptor(req)
+	}
+	c.logRequest(req)
+
+	httpClient := c.httpClient
+	if req.Header.Get("Accept") == "text/event-stream" && c.streamHTTPClient != nil {
+		httpClient = c.streamHTTPClient
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if c.metrics != nil {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		c.metrics.ObserveRequest(endpoint, status, time.Since(start))
+	}
+	span := spanFromContext(req.Context())
+	span.SetAttribute(attributeEndpoint, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttribute(attributeStatusCode, resp.StatusCode)
+
+	if c.compression {
+		decompress(resp)
+	}
+	if c.maxResponseBytes > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: c.maxResponseBytes}
+	}
+	for _, interceptor := range c.responseInterceptors {
+		interceptor(resp)
+	}
+	return resp, nil
+}
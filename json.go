@@ -0,0 +1,85 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// jsonInstruction is the system instruction ChatJSON adds to the request.
+const jsonInstruction = "Respond only with valid JSON, without any explanations or Markdown formatting."
+
+// ChatJSON sends req with Chat and decodes the answer as JSON into a value of type T,
+// e.g. a struct describing the expected output. The answer's format is only
+// requested in the prompt, since GigaChat has no native JSON mode: ChatJSON adds
+// an instruction to the system message, or a system message if req has none.
+// A Markdown code fence around the JSON is stripped. req itself is not modified.
+//
+// If the answer is not valid JSON for T, the returned error contains the answer.
+func ChatJSON[T any](ctx context.Context, c *Client, req *ChatRequest, opts ...RequestOption) (T, error) {
+	var result T
+
+	req = c.withDefaultModel(req)
+	if err := req.Validate(); err != nil {
+		return result, err
+	}
+	jsonReq := *req
+	jsonReq.Messages = withJSONInstruction(req.Messages)
+
+	resp, err := c.Chat(ctx, &jsonReq, opts...)
+	if err != nil {
+		return result, err
+	}
+	choice, err := resp.BestChoice()
+	if err != nil {
+		return result, err
+	}
+
+	content := stripCodeFence(choice.Message.Content)
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return result, fmt.Errorf("response is not valid JSON: %w: %q", err, content)
+	}
+	return result, nil
+}
+
+// withJSONInstruction returns a copy of messages with jsonInstruction added to the
+// system message, which is prepended if there is none.
+func withJSONInstruction(messages []Message) []Message {
+	if len(messages) > 0 && messages[0].Role == RoleSystem {
+		messages = slices.Clone(messages)
+		messages[0].Content = strings.TrimSpace(messages[0].Content + "\n\n" + jsonInstruction)
+		return messages
+	}
+	return append([]Message{{Role: RoleSystem, Content: jsonInstruction}}, messages...)
+}
+
+// stripCodeFence returns content without a surrounding Markdown code fence such as ```json.
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	body, ok := strings.CutPrefix(content, "```")
+	if !ok {
+		return content
+	}
+	body, ok = strings.CutSuffix(body, "```")
+	if !ok {
+		return content
+	}
+	// Drop the language tag after the opening fence. A fence on a single line,
+	// e.g. ```json {"a":1}```, separates it from the content with a space.
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else if i := strings.IndexAny(body, " \t"); i > 0 && isLanguageTag(body[:i]) {
+		body = body[i+1:]
+	}
+	return strings.TrimSpace(body)
+}
+
+// isLanguageTag reports whether s looks like the language identifier of a code fence.
+func isLanguageTag(s string) bool {
+	return !strings.ContainsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '+' && r != '_'
+	})
+}
@@ -0,0 +1,28 @@
+package gigago
+
+import "log"
+
+// Logger is the interface used by the client for its internal logging.
+// *log.Logger satisfies it, and it is easy to adapt slog, zap, or any other logger.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// WithLogger provides an Option to route the client's internal log output
+// to a custom Logger. Defaults to the standard library's log.Default().
+// To silence logging entirely, pass log.New(io.Discard, "", 0).
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// logf writes a log line through the configured Logger,
+// falling back to the standard logger if none is set.
+func (c *Client) logf(format string, args ...any) {
+	if c.logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	c.logger.Printf(format, args...)
+}
@@ -0,0 +1,50 @@
+package gigago
+
+import (
+	"strings"
+	"time"
+)
+
+// oauthEndpoint is the endpoint name reported to Metrics for token requests.
+const oauthEndpoint = "oauth"
+
+// Metrics receives measurements of the HTTP requests made by the client.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest is called after every HTTP request, including retries, with
+	// the endpoint (an API path such as "/chat/completions", or "oauth" for token
+	// requests), the response status code or 0 if no response was received, and
+	// the wall-clock duration until the response headers arrived.
+	ObserveRequest(endpoint string, status int, d time.Duration)
+}
+
+// FirstTokenMetrics is implemented by Metrics that also want the time to first
+// token of streamed completions. It is optional, so existing Metrics keep working.
+type FirstTokenMetrics interface {
+	// ObserveFirstToken is called once per ChatStream, when the first chunk with
+	// content arrives, with the model of the request and the wall-clock duration
+	// since ChatStream was called. See ChatStream.FirstTokenLatency.
+	ObserveFirstToken(model string, d time.Duration)
+}
+
+// noopMetrics is the Metrics used unless WithMetrics is set.
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveRequest(string, int, time.Duration) {}
+
+// WithMetrics provides an Option to report the latency and outcome of every
+// OAuth and API request to m, e.g. to feed a latency histogram and error counters.
+func WithMetrics(m Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// metricsEndpoint returns the endpoint name reported for an API path. Paths
+// containing IDs are collapsed into a template to keep the number of names small.
+func metricsEndpoint(path string) string {
+	if strings.HasPrefix(path, filesPath+"/") && strings.HasSuffix(path, "/content") {
+		return filesPath + "/{id}/content"
+	}
+	return path
+}
@@ -0,0 +1,62 @@
+package gigago
+
+import (
+	"context"
+	"net/http"
+)
+
+const modelsPath = "/models"
+
+// Model describes a model available to the account.
+type Model struct {
+	// ID is the model name to use in requests, e.g. "GigaChat-Pro".
+	ID string `json:"id"`
+
+	// Object is the type of the API object, typically "model".
+	Object string `json:"object"`
+
+	// OwnedBy is the owner of the model.
+	OwnedBy string `json:"owned_by"`
+
+	// Type is the kind of model, e.g. "chat" or "embedder".
+	Type string `json:"type,omitempty"`
+}
+
+type modelsResponse struct {
+	Data   []Model `json:"data"`
+	Object string  `json:"object"`
+}
+
+// Models returns the list of models available to the account.
+// Non-2xx responses are returned as *APIError.
+func (c *Client) Models(ctx context.Context, opts ...RequestOption) ([]Model, error) {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx, span := c.startSpan(ctx, "gigago.Models", "")
+	var resp modelsResponse
+	err := c.doRequest(ctx, http.MethodGet, modelsPath, nil, &resp, opts)
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Ping checks that the client can authenticate and reach the GigaChat API, for use
+// in readiness probes. Unlike EnsureToken, which only talks to OAuth, it also
+// validates the API host: it sends a models list request, the cheapest
+// authenticated endpoint, which consumes no tokens of the account's quota. The
+// access token is refreshed first if needed.
+//
+// Ping returns nil on success. OAuth failures wrap an *AuthError and API
+// failures are returned as *APIError.
+func (c *Client) Ping(ctx context.Context, opts ...RequestOption) error {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx, span := c.startSpan(ctx, "gigago.Ping", "")
+	err := c.doRequest(ctx, http.MethodGet, modelsPath, nil, nil, opts)
+	span.End(err)
+	return err
+}
@@ -12,12 +12,17 @@ import (
 	"github.com/google/uuid"
 )
 
-type tokenResponse struct {
+// Token is an OAuth access token issued by the GigaChat OAuth endpoint.
+type Token struct {
+	// AccessToken is the bearer token sent with API requests.
 	AccessToken string `json:"access_token"`
-	ExpiresAt   int64  `json:"expires_at"`
+	// ExpiresAt is the expiration time of the token in Unix milliseconds.
+	ExpiresAt int64 `json:"expires_at"`
 }
 
-func (c *Client) oauthCreate(ctx context.Context) (*tokenResponse, error) {
+// oauthRequest requests a new access token from the OAuth endpoint with apiKey,
+// or with the client certificate if one is configured.
+func (c *Client) oauthRequest(ctx context.Context, apiKey string) (*Token, error) {
 	data := url.Values{}
 	data.Set("scope", c.scope)
 
@@ -28,26 +33,36 @@ func (c *Client) oauthCreate(ctx context.Context) (*tokenResponse, error) {
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
+	c.setUserAgent(req)
 
 	// Set a unique request ID for tracing, as required by the Sberbank API.
 	req.Header.Set("RqUID", uuid.NewString())
-	req.Header.Set("Authorization", "Basic "+c.apiKey)
+	if !c.certAuth {
+		req.Header.Set("Authorization", "Basic "+apiKey)
+	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(oauthEndpoint, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
-	defer resp.Body.Close()
+	defer drainAndClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("oauth request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, &AuthError{StatusCode: resp.StatusCode, Body: body}
 	}
 
-	var token tokenResponse
+	var token Token
 	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	return &token, nil
 }
+
+// setUserAgent sets the configured User-Agent header on req, if any.
+func (c *Client) setUserAgent(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
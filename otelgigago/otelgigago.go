@@ -0,0 +1,67 @@
+// Package otelgigago traces gigago clients with OpenTelemetry.
+//
+// It lives in its own module, github.com/Role1776/gigago/otelgigago, so that
+// applications that don't use OpenTelemetry don't depend on it:
+//
+//	client, err := gigago.NewClient(ctx, key,
+//		otelgigago.WithTracing(otel.Tracer("myapp")),
+//	)
+package otelgigago
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Role1776/gigago"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracing returns a gigago.Option that records a span with tracer for every
+// API call and token refresh, and injects the trace context into outgoing request
+// headers using the global propagator (see otel.SetTextMapPropagator).
+func WithTracing(tracer trace.Tracer) gigago.Option {
+	return gigago.WithTracer(&otelTracer{tracer: tracer})
+}
+
+// otelTracer adapts an OpenTelemetry tracer to gigago.Tracer.
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t *otelTracer) Start(ctx context.Context, name string) (context.Context, gigago.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, otelSpan{span: span}
+}
+
+func (t *otelTracer) Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// otelSpan adapts an OpenTelemetry span to gigago.Span.
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value any) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
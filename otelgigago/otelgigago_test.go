@@ -0,0 +1,63 @@
+package otelgigago
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Role1776/gigago"
+	"github.com/Role1776/gigago/gigagotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithTracing(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var traceparents []string
+	client := gigagotest.NewTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("Traceparent"))
+		if r.URL.Path == "/embeddings" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gigagotest.ChatResponse("Paris.").ServeHTTP(w, r)
+	}), WithTracing(provider.Tracer("test")))
+
+	_, err := client.Chat(t.Context(), &gigago.ChatRequest{Model: "GigaChat", Messages: []gigago.Message{{Role: gigago.RoleUser, Content: "The capital of France is"}}})
+	require.NoError(t, err)
+	_, err = client.Embeddings(t.Context(), &gigago.EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}})
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	chat, embeddings := spans[0], spans[1]
+
+	assert.Equal(t, "gigago.Chat", chat.Name())
+	assert.Equal(t, trace.SpanKindClient, chat.SpanKind())
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("gen_ai.request.model", "GigaChat"),
+		attribute.String("gigago.endpoint", "/chat/completions"),
+		attribute.Int("http.response.status_code", http.StatusOK),
+	}, chat.Attributes())
+	assert.Equal(t, codes.Unset, chat.Status().Code)
+
+	assert.Equal(t, "gigago.Embeddings", embeddings.Name())
+	assert.Contains(t, embeddings.Attributes(), attribute.Int("http.response.status_code", http.StatusBadRequest))
+	assert.Equal(t, codes.Error, embeddings.Status().Code)
+	assert.Equal(t, err.Error(), embeddings.Status().Description)
+	require.NotEmpty(t, embeddings.Events())
+	assert.Equal(t, "exception", embeddings.Events()[0].Name)
+
+	require.Len(t, traceparents, 2)
+	assert.Contains(t, traceparents[0], chat.SpanContext().TraceID().String(), "the trace context must be propagated")
+	assert.Contains(t, traceparents[1], embeddings.SpanContext().TraceID().String())
+}
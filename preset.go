@@ -0,0 +1,36 @@
+package gigago
+
+// Preset is a named set of sampling parameters for requests that should behave
+// consistently without tuning the numbers by hand, see ChatRequest.ApplyPreset.
+type Preset struct {
+	// Temperature is applied to ChatRequest.Temperature.
+	Temperature float64
+	// TopP is applied to ChatRequest.TopP.
+	TopP float64
+	// RepetitionPenalty is applied to ChatRequest.RepetitionPenalty.
+	RepetitionPenalty float64
+}
+
+var (
+	// PresetCreative favors varied, imaginative answers, e.g. for brainstorming
+	// or fiction, and discourages repetition.
+	PresetCreative = Preset{Temperature: 1.1, TopP: 0.9, RepetitionPenalty: 1.1}
+
+	// PresetPrecise favors focused, near-deterministic answers, e.g. for
+	// extraction, classification or code.
+	PresetPrecise = Preset{Temperature: 0.1, TopP: 0.1, RepetitionPenalty: 1.0}
+)
+
+// ApplyPreset sets the sampling parameters of r that are still unset (nil) to the
+// values of p, so fields set explicitly, before or after, take precedence.
+func (r *ChatRequest) ApplyPreset(p Preset) {
+	if r.Temperature == nil {
+		r.Temperature = Ptr(p.Temperature)
+	}
+	if r.TopP == nil {
+		r.TopP = Ptr(p.TopP)
+	}
+	if r.RepetitionPenalty == nil {
+		r.RepetitionPenalty = Ptr(p.RepetitionPenalty)
+	}
+}
@@ -0,0 +1,116 @@
+package gigago
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit provides an Option to pace API calls on the client side with a
+// token bucket allowing rps requests per second and bursts of up to burst requests.
+// Each API call waits for a free slot before it is sent, or fails with the context's
+// error if ctx is done first. Background token refreshes are not rate limited.
+// By default there is no limit.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(c *Client) {
+		c.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+	}
+}
+
+// WithRateLimitRetry provides an Option to retry API calls rejected with a
+// retryable status, 429 Too Many Requests or a 5xx server error by default (see
+// WithRetryableStatusCodes), up to maxRetries times. Before each retry the client
+// waits as long as the response's Retry-After header asks (one second if it is
+// absent), or until the call's context is done. If all retries are rejected, the
+// last response is returned as *APIError. Defaults to no retries.
+func WithRateLimitRetry(maxRetries int) Option {
+	return func(c *Client) {
+		c.rateLimitMaxRetries = maxRetries
+	}
+}
+
+// WithMaxConcurrentRequests provides an Option to cap the number of API calls in
+// flight at once to n. Further calls wait for one of them to finish, or fail with
+// the context's error if ctx is done first. A call is in flight until its response
+// has been read, so a ChatStream holds its slot until it ends or is closed, and
+// DownloadFile until the returned body is closed. Unlike WithRateLimit, which paces
+// calls over time, this caps their concurrency. Token refreshes are not limited.
+// By default, or if n is zero, the concurrency is not limited. n must not be negative.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(c *Client) {
+		c.maxConcurrentRequests = n
+		c.requestSlots = nil
+		if n > 0 {
+			c.requestSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// acquireRequestSlot waits for a free slot of WithMaxConcurrentRequests and returns
+// the function releasing it. It returns a nil function if concurrency is not limited.
+func (c *Client) acquireRequestSlot(ctx context.Context) (func(), error) {
+	if c.requestSlots == nil {
+		return nil, nil
+	}
+
+	select {
+	case c.requestSlots <- struct{}{}:
+		return func() { <-c.requestSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaseOnClose is a response body that releases a request slot once it is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// defaultRetryableStatusCodes are the statuses retried by WithRateLimitRetry by default.
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// WithRetryableStatusCodes provides an Option to set which responses are retried
+// with the retries enabled by WithRateLimitRetry. Responses with other statuses
+// are returned as *APIError immediately. Defaults to 429, 500, 502, 503 and 504.
+// Calling it without codes disables retries.
+func WithRetryableStatusCodes(codes ...int) Option {
+	return func(c *Client) {
+		c.retryableStatusCodes = append([]int{}, codes...)
+	}
+}
+
+// isRetryableStatus reports whether a response with status should be retried.
+func (c *Client) isRetryableStatus(status int) bool {
+	codes := c.retryableStatusCodes
+	if codes == nil {
+		codes = defaultRetryableStatusCodes
+	}
+	return slices.Contains(codes, status)
+}
+
+// waitRateLimit blocks until the rate limiter configured with WithRateLimit allows
+// another request, or ctx is done.
+func (c *Client) waitRateLimit(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Wait(ctx)
+}
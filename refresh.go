@@ -2,100 +2,258 @@ package gigago
 
 import (
 	"context"
-	"log"
+	"errors"
+	"math/rand/v2"
+	"net/http"
 	"time"
 )
 
 const (
-	// tokenRefreshBuffer is the time buffer before token expiration to trigger refresh
-	tokenRefreshBuffer = 15 * time.Minute
-	// tokenRefreshInterval is how often to check if token needs refresh
-	tokenRefreshInterval = 1 * time.Minute
+	// defaultTokenRefreshBuffer is the default time buffer before token expiration to trigger refresh
+	defaultTokenRefreshBuffer = 15 * time.Minute
+	// defaultTokenRefreshInterval is the default interval for checking if the token needs refresh
+	defaultTokenRefreshInterval = 1 * time.Minute
 	// refreshTimeout is the timeout for token refresh requests
 	refreshTimeout = 30 * time.Second
+	// defaultStreamRefreshBuffer is the default minimum remaining token lifetime for starting a stream
+	defaultStreamRefreshBuffer = refreshTimeout + time.Minute
 )
 
 // isValid checks if the token is still fresh enough for use.
-// It returns true if the token's expiration time is more than 15 minutes in the future.
-// This 15-minute buffer provides a safe window to prevent using an expired token
+// It returns true if the token's expiration time is further in the future than
+// the client's refresh buffer (15 minutes by default, see WithTokenRefreshBuffer).
+// This buffer provides a safe window to prevent using an expired token
 // for requests that might take time to complete.
 // The expire_at timestamp is expected to be in Unix milliseconds.
 func (c *Client) isValid(expire_at int64, now time.Time) bool {
+	return isValidFor(expire_at, now, c.refreshBuffer)
+}
+
+// isValidFor is like isValid, but with the given buffer instead of the client's.
+func isValidFor(expire_at int64, now time.Time, buffer time.Duration) bool {
 	nowMs := now.UnixNano() / int64(time.Millisecond)
 
 	remaining := expire_at - nowMs
 
-	fifteenMinutesMs := int64(tokenRefreshBuffer / time.Millisecond)
+	bufferMs := int64(buffer / time.Millisecond)
+
+	return remaining > bufferMs
+}
+
+// isExpired reports whether the token has already expired and can no longer be used.
+// Unlike isValid, it applies no buffer. The expire_at timestamp is expected to be in Unix milliseconds.
+func (c *Client) isExpired(expire_at int64, now time.Time) bool {
+	return expire_at <= now.UnixMilli()
+}
+
+// ensureRequestToken prepares the access token for an API request. A missing or
+// expired token must be refreshed, and a failed refresh fails the request. A token
+// that is merely inside the refresh buffer is refreshed too, but if that fails the
+// request proceeds with the current token, which is still accepted by the API.
+func (c *Client) ensureRequestToken(ctx context.Context) error {
+	c.mu.RLock()
+	token := c.accessToken
+	c.mu.RUnlock()
+
+	now := c.now()
+	switch {
+	case token == nil || c.isExpired(token.ExpiresAt, now):
+		return c.refreshToken(ctx)
+	case c.isValid(token.ExpiresAt, now):
+		return nil
+	}
+
+	if err := c.refreshToken(ctx); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		c.logf("gigago: failed to refresh token, using the current one until it expires: %v", err)
+	}
+	return nil
+}
+
+// ensureStreamToken refreshes the access token before a stream is started if it
+// would expire within the stream refresh buffer (see WithStreamRefreshBuffer), so
+// that a long stream does not outlive its token. Unlike ensureRequestToken, a
+// failed refresh fails the stream. A missing token is left to ensureRequestToken.
+func (c *Client) ensureStreamToken(ctx context.Context) error {
+	if c.streamRefreshBuffer <= 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	token := c.accessToken
+	c.mu.RUnlock()
+
+	if token == nil || isValidFor(token.ExpiresAt, c.now(), c.streamRefreshBuffer) {
+		return nil
+	}
+	return c.replaceToken(ctx, token.AccessToken)
+}
+
+// EnsureToken makes sure the client holds a usable access token, refreshing it
+// synchronously if there is none or it is about to expire. It returns immediately
+// if the current token is still valid. Concurrent calls share a single refresh.
+//
+// EnsureToken is useful in readiness probes to block until the client is able
+// to authenticate requests.
+func (c *Client) EnsureToken(ctx context.Context) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+
+	c.mu.RLock()
+	valid := c.accessToken != nil && c.isValid(c.accessToken.ExpiresAt, c.now())
+	c.mu.RUnlock()
+
+	if valid {
+		return nil
+	}
+	return c.refreshToken(ctx)
+}
+
+// TokenExpiresAt returns the expiration time of the current access token.
+// It returns the zero time if no token has been fetched yet.
+// It never triggers a refresh.
+func (c *Client) TokenExpiresAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.accessToken == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(c.accessToken.ExpiresAt)
+}
+
+// TokenValid reports whether the current access token is present and not yet
+// inside the refresh buffer (see WithTokenRefreshBuffer). It never triggers a refresh.
+func (c *Client) TokenValid() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	return remaining > fifteenMinutesMs
+	return c.accessToken != nil && c.isValid(c.accessToken.ExpiresAt, c.now())
 }
 
 // tokenRefresher runs in a background goroutine to proactively refresh the access token.
-// It wakes up periodically (every minute) to check if the current token is nearing
-// expiration. If it is, it triggers a refresh. Errors during the refresh are logged
-// but do not stop the refresher, allowing it to retry on the next tick.
-// The goroutine terminates when the client's stop channel is closed or its context is done.
+// It wakes up periodically (every minute by default) to check if the current token is nearing
+// expiration. If it is, it triggers a refresh. Errors during the refresh are reported
+// via reportRefreshError but do not stop the refresher, allowing it to retry on the next
+// tick, unless the OAuth endpoint rejected the credentials (see AuthError.Permanent).
+// Each wait is extended by a random jitter if one is configured with WithRefreshJitter.
+// The goroutine terminates when its context is done, aborting a refresh in progress
+// without reporting it as an error.
 func (c *Client) tokenRefresher(ctx context.Context) {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(tokenRefreshInterval)
-	defer ticker.Stop()
+	timer := c.newTimer(c.nextRefreshDelay())
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C():
 			// Check if context is cancelled before proceeding
 			if ctx.Err() != nil {
 				return
 			}
 
+			// A missing token, e.g. if the initial fetch was skipped, is refreshed
+			// like an expired one.
 			c.mu.RLock()
-			shouldRefresh := !c.isValid(c.accessToken.ExpiresAt, time.Now())
+			shouldRefresh := c.accessToken == nil || !c.isValid(c.accessToken.ExpiresAt, c.now())
 			c.mu.RUnlock()
 
 			if shouldRefresh {
+				// The refresh, including its retries and the waits between them,
+				// is bound to ctx, so Close aborts it.
 				reqCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
 				err := c.refreshToken(reqCtx)
 				cancel()
 
+				if ctx.Err() != nil {
+					return
+				}
 				if err != nil {
-					log.Printf("gigago: failed to refresh token in background: %v", err)
+					c.reportRefreshError(err)
+				}
+				if isPermanentAuthError(err) {
+					return
 				}
 			}
 
+			timer.Reset(c.nextRefreshDelay())
+
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// nextRefreshDelay returns the time to wait before the next background token check:
+// the refresh interval plus a random duration in [0, refreshJitter).
+func (c *Client) nextRefreshDelay() time.Duration {
+	if c.refreshJitter <= 0 {
+		return c.refreshInterval
+	}
+	return c.refreshInterval + rand.N(c.refreshJitter)
+}
+
+// reportRefreshError passes a background refresh failure to the handler configured
+// with WithRefreshErrorHandler, or logs it if no handler is set.
+// It must be called without holding c.mu or c.refreshMu.
+func (c *Client) reportRefreshError(err error) {
+	if c.refreshErrorHandler != nil {
+		c.refreshErrorHandler(err)
+		return
+	}
+
+	c.logf("gigago: failed to refresh token in background: %v", err)
+	if isPermanentAuthError(err) {
+		c.logf("gigago: credentials rejected, stopping background token refresh")
+	}
+}
+
+// refreshToken fetches a new access token and stores it on the client.
+// Concurrent callers are coalesced into a single OAuth request and all receive its result;
+// a caller waiting for another's refresh returns early with ctx.Err() if ctx is done.
+// If the OAuth endpoint responds with a non-200 status, the returned error wraps an *AuthError.
 func (c *Client) refreshToken(ctx context.Context) error {
+	return c.replaceToken(ctx, "")
+}
+
+// replaceToken is like refreshToken, but if stale is not empty and the client's
+// token no longer equals it, another caller has already replaced the rejected
+// token and replaceToken returns nil without a new OAuth request. It is used after
+// a 401 response, so that requests rejected together cause a single refresh even
+// if they observe the 401 after the refresh has completed.
+func (c *Client) replaceToken(ctx context.Context, stale string) error {
 	c.refreshMu.Lock()
 	if c.refreshing {
+		// The channel is buffered so the broadcast below never blocks,
+		// even if this waiter has already given up.
 		ch := make(chan error, 1)
 		c.refreshWaiters = append(c.refreshWaiters, ch)
 		c.refreshMu.Unlock()
-		return <-ch
+
+		select {
+		case err := <-ch:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if stale != "" {
+		c.mu.RLock()
+		replaced := c.accessToken != nil && c.accessToken.AccessToken != stale
+		c.mu.RUnlock()
+		if replaced {
+			c.refreshMu.Unlock()
+			return nil
+		}
 	}
 	c.refreshing = true
 	c.refreshMu.Unlock()
 
-	// Select the function to get the token
-	var (
-		token *tokenResponse
-		err   error
-	)
-	if c.oauthCreateFunc != nil {
-		token, err = c.oauthCreateFunc(ctx)
-	} else {
-		token, err = c.oauthCreate(ctx)
-	}
-
-	c.mu.Lock()
-	if err == nil {
-		c.accessToken = token
-	}
-	c.mu.Unlock()
+	_, err := c.obtainToken(ctx)
 
 	c.refreshMu.Lock()
 	for _, waiter := range c.refreshWaiters {
@@ -108,3 +266,75 @@ func (c *Client) refreshToken(ctx context.Context) error {
 
 	return err
 }
+
+// obtainToken fetches a new token with fetchToken, puts it in use and saves it to
+// the token store, calling the refresh hooks around it and recording it on a span.
+func (c *Client) obtainToken(ctx context.Context) (*Token, error) {
+	if c.beforeRefresh != nil {
+		c.beforeRefresh(ctx)
+	}
+
+	spanCtx, span := c.startSpan(ctx, "gigago.refreshToken", "")
+	token, err := c.fetchToken(spanCtx)
+	span.End(err)
+
+	if err == nil {
+		c.mu.Lock()
+		c.accessToken = token
+		c.mu.Unlock()
+		c.saveToken(ctx, token)
+	}
+	if c.afterRefresh != nil {
+		c.afterRefresh(ctx, token, err)
+	}
+	return token, err
+}
+
+// fetchToken requests a new token from the OAuth endpoint, retrying transient
+// failures with exponential backoff as configured by WithRefreshRetry.
+// Rejected credentials and other 4xx responses are not retried.
+// Waiting between attempts stops as soon as ctx is done.
+func (c *Client) fetchToken(ctx context.Context) (*Token, error) {
+	attempts := max(c.refreshMaxAttempts, 1)
+	delay := c.refreshBaseDelay
+
+	for attempt := 1; ; attempt++ {
+		// Select the function to get the token
+		var (
+			token *Token
+			err   error
+		)
+		if c.oauthCreateFunc != nil {
+			token, err = c.oauthCreateFunc(ctx)
+		} else {
+			token, err = c.oauthCreate(ctx)
+		}
+
+		if err == nil || attempt >= attempts || !isRetryableRefreshError(err) {
+			return token, err
+		}
+
+		timer := c.newTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// isRetryableRefreshError reports whether a failed token request is worth retrying:
+// transport errors and 429/5xx responses are, other OAuth rejections are not.
+func isRetryableRefreshError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.StatusCode == http.StatusTooManyRequests || authErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,410 @@
+package gigago
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRetryAfter is how long to wait before retrying a 429 response
+	// that doesn't carry a usable Retry-After header.
+	defaultRetryAfter = time.Second
+	// maxDrainSize is how much of an unread response body is discarded before
+	// closing it so that the connection can be reused. Larger remainders are
+	// not worth reading and the connection is closed instead.
+	maxDrainSize = 64 << 10
+	// defaultMaxResponseBytes is the default limit on the size of a response body.
+	defaultMaxResponseBytes = 64 << 20
+)
+
+// RequestOption configures a single API call, such as Chat or Embeddings.
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	header http.Header
+	// autoContinue is the maximum number of continuations requested by Chat.
+	autoContinue int
+	// autoTrim is the token budget Chat trims the messages to, see WithAutoTrim.
+	autoTrim int
+	// idempotencyKey overrides the generated idempotency key, see WithIdempotencyKey.
+	idempotencyKey string
+}
+
+// WithHeader provides a RequestOption to set an extra header on the request, e.g.
+// X-Request-ID or X-Client-ID. It takes precedence over the client's own headers
+// and those set with WithDefaultHeaders, except for Authorization, which is always
+// set by the client.
+func WithHeader(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Set(key, value)
+	}
+}
+
+// serverTimeoutHeader is the header proxies and gateways in front of GigaChat read
+// the server-side timeout of a request from.
+const serverTimeoutHeader = "X-Request-Timeout"
+
+// WithServerTimeout provides a RequestOption to ask the server to abort the request
+// if it takes longer than d, e.g. a stuck generation. It sets the X-Request-Timeout
+// header to d in whole seconds, rounded up. The header is only honored by gateways
+// that support it and is independent of the context deadline, which still bounds
+// how long the client waits. Values of zero or less leave the header unset.
+func WithServerTimeout(d time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		if d <= 0 {
+			o.header.Del(serverTimeoutHeader)
+			return
+		}
+		seconds := (d + time.Second - 1) / time.Second
+		o.header.Set(serverTimeoutHeader, strconv.FormatInt(int64(seconds), 10))
+	}
+}
+
+// newRequestOptions applies opts on top of a copy of header.
+func newRequestOptions(header http.Header, opts []RequestOption) requestOptions {
+	o := requestOptions{header: header.Clone()}
+	if o.header == nil {
+		o.header = http.Header{}
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// requestHeader returns a copy of header with opts applied on top of it, for a
+// request with the given method, including the idempotency key, if enabled.
+func (c *Client) requestHeader(method string, header http.Header, opts []RequestOption) http.Header {
+	o := newRequestOptions(header, opts)
+	c.setIdempotencyKey(o.header, method, o.idempotencyKey)
+	return o.header
+}
+
+// requestBody returns a fresh reader for the request body on each attempt,
+// so that a request can be retried.
+type requestBody func() (io.Reader, error)
+
+// jsonBody encodes in as JSON and returns a requestBody replaying the encoded data.
+func jsonBody(in any) (requestBody, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	return func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}, nil
+}
+
+// doRequest sends an authenticated request to the GigaChat API endpoint at path
+// and decodes the JSON response into out. If in is not nil, it is encoded as the
+// JSON request body. If out is nil, the response body is discarded.
+// Headers set by opts are added to the request.
+//
+// The access token is refreshed before sending if it is missing or about to expire.
+// If the API responds with 401 Unauthorized, the token is refreshed and the request
+// is retried once. Non-2xx responses are returned as *APIError.
+// The timeout set by WithRequestTimeout is applied by the public methods calling
+// doRequest, once for the whole call.
+func (c *Client) doRequest(ctx context.Context, method, path string, in, out any, opts []RequestOption) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+
+	var body requestBody
+	if in != nil {
+		var err error
+		if body, err = jsonBody(in); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.send(ctx, method, path, body, c.requestHeader(method, nil, opts))
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
+
+// Do sends an authenticated request to an API endpoint not covered by the typed
+// methods and returns the raw response for the caller to decode. path is appended
+// to the API base URL, e.g. "/balance". If body is not nil, it is encoded as the
+// JSON request body.
+//
+// Do handles authentication, rate limiting and retries like the typed methods,
+// but returns responses of any status as they are instead of as *APIError. The
+// caller must close the response body; the timeout set by WithRequestTimeout
+// keeps running until then.
+func (c *Client) Do(ctx context.Context, method, path string, body any, opts ...RequestOption) (*http.Response, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var reqBody requestBody
+	if body != nil {
+		var err error
+		if reqBody, err = jsonBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	ctx, span := c.startSpan(ctx, "gigago.Do", "")
+	resp, err := c.send(ctx, method, path, reqBody, c.requestHeader(method, nil, opts))
+	span.End(err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// requestIDHeader is the response header carrying the ID the API assigned to a request.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDSetter is implemented by responses that record the request ID.
+type requestIDSetter interface {
+	setRequestID(id string)
+}
+
+// decodeResponse decodes the JSON body of resp into out and closes it.
+// Non-2xx responses are returned as *APIError, bodies that are not valid JSON as
+// *ResponseDecodeError. If out is nil, the body is discarded.
+// If out implements requestIDSetter, it receives the request ID of the response.
+func decodeResponse(resp *http.Response, out any) error {
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkJSONContentType(resp.Header.Get("Content-Type")); err != nil {
+		return newResponseDecodeError(resp, body, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return newResponseDecodeError(resp, body, err)
+	}
+	if setter, ok := out.(requestIDSetter); ok {
+		setter.setRequestID(resp.Header.Get(requestIDHeader))
+	}
+	return nil
+}
+
+// checkJSONContentType returns an error if contentType, the Content-Type of a
+// response, rules out a UTF-8 JSON body, e.g. for an HTML page served by a proxy.
+// A missing Content-Type and text/plain, which some gateways send for JSON, are
+// accepted.
+func checkJSONContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") && mediaType != "text/plain" {
+		return fmt.Errorf("unexpected content type %q, want application/json", contentType)
+	}
+	if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		return fmt.Errorf("unsupported charset %q in content type %q", params["charset"], contentType)
+	}
+	return nil
+}
+
+// send waits for the rate limiter, ensures a usable access token (see
+// ensureRequestToken), and performs the HTTP request. On a 401 Unauthorized
+// response it refreshes the token and retries exactly once. On a retryable status
+// (see WithRetryableStatusCodes) it waits as long as the Retry-After header asks
+// and retries, up to the limit set by WithRateLimitRetry.
+// If body is not nil, it is called before each attempt and sent as a JSON body
+// unless header sets a different Content-Type. The headers set with
+// WithDefaultHeaders are applied after the client's own, and values in header
+// after those. Authorization is always set last.
+// The caller is responsible for closing the returned response body.
+//
+// If WithMaxConcurrentRequests is set, send first waits for a free request slot,
+// which is held until the returned body is closed.
+func (c *Client) send(ctx context.Context, method, path string, body requestBody, header http.Header) (*http.Response, error) {
+	release, err := c.acquireRequestSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return c.sendRequest(ctx, method, path, body, header)
+	}
+
+	resp, err := c.sendRequest(ctx, method, path, body, header)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// sendRequest is send without the concurrency limit.
+func (c *Client) sendRequest(ctx context.Context, method, path string, body requestBody, header http.Header) (*http.Response, error) {
+	if err := c.waitRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := c.ensureRequestToken(ctx); err != nil {
+		return nil, fmt.Errorf("failed to obtain access token: %w", err)
+	}
+
+	refreshed := false
+	rateLimitRetries := 0
+
+	for {
+		var token string
+		c.mu.RLock()
+		token = c.accessToken.AccessToken
+		c.mu.RUnlock()
+
+		var reader io.Reader
+		if body != nil {
+			var err error
+			if reader, err = body(); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURLAI+path, reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+		c.setUserAgent(req)
+		for key, values := range c.defaultHeaders {
+			req.Header[key] = values
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := c.do(metricsEndpoint(path), req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && !refreshed:
+			drainAndClose(resp.Body)
+			refreshed = true
+
+			if err := c.replaceToken(ctx, token); err != nil {
+				return nil, fmt.Errorf("failed to refresh token after 401: %w", err)
+			}
+
+		case c.isRetryableStatus(resp.StatusCode) && rateLimitRetries < c.rateLimitMaxRetries:
+			drainAndClose(resp.Body)
+			rateLimitRetries++
+
+			if err := sleepContext(ctx, retryAfter(resp.Header, time.Now())); err != nil {
+				return nil, err
+			}
+
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying according to the
+// Retry-After header, given either in seconds or as an HTTP date.
+// It falls back to defaultRetryAfter if the header is missing or malformed.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return defaultRetryAfter
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withRequestTimeout bounds ctx by the timeout configured with WithRequestTimeout,
+// unless ctx already carries a deadline or no timeout is configured.
+func (c *Client) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// drainAndClose discards what is left of body, up to maxDrainSize, and closes it,
+// so that the underlying connection can return to the pool.
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainSize))
+	body.Close()
+}
+
+// limitedBody is a response body that fails with ErrResponseTooLarge once more
+// than remaining bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only fail if there actually is data beyond the limit.
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,71 @@
+package gigago
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// redactedHeaders lists the request headers whose values are replaced with "***" in request logs.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// WithRequestLogging provides an Option to log every outgoing request, OAuth and
+// API alike, to logger for auditing. Each line holds the method, the URL, the
+// headers and at most maxBody bytes of the body; a longer body is truncated, and
+// zero omits it. Credentials never appear in the log: the values of the
+// Authorization, Proxy-Authorization and Cookie headers are replaced with "***".
+// Bodies of file uploads are not logged.
+func WithRequestLogging(logger Logger, maxBody int) Option {
+	return func(c *Client) {
+		c.requestLogger = logger
+		c.requestLogBodySize = maxBody
+	}
+}
+
+// logRequest writes req to the request logger, if one is set.
+func (c *Client) logRequest(req *http.Request) {
+	if c.requestLogger == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var headers strings.Builder
+	for _, key := range keys {
+		value := strings.Join(req.Header.Values(key), ", ")
+		if slices.Contains(redactedHeaders, key) {
+			value = "***"
+		}
+		fmt.Fprintf(&headers, " %s=%q", key, value)
+	}
+
+	c.requestLogger.Printf("gigago: request %s %s%s%s", req.Method, req.URL.Redacted(), headers.String(), c.bodyPreview(req))
+}
+
+// bodyPreview returns the start of the request body for the request log,
+// or an empty string if the body is empty, unavailable or not logged.
+func (c *Client) bodyPreview(req *http.Request) string {
+	if c.requestLogBodySize <= 0 || req.GetBody == nil {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(body, int64(c.requestLogBodySize)+1))
+	if len(data) == 0 {
+		return ""
+	}
+	if len(data) > c.requestLogBodySize {
+		return fmt.Sprintf(" body=%q (truncated)", data[:c.requestLogBodySize])
+	}
+	return fmt.Sprintf(" body=%q", data)
+}
@@ -13,8 +13,21 @@ const (
 	// RoleSystem provides context or instructions for the model.
 	// It typically appears once at the beginning of a conversation.
 	RoleSystem Role = "system"
+
+	// RoleFunction carries the result of a function called by the model.
+	// Its Content is the function's JSON result and Name is the function name.
+	RoleFunction Role = "function"
 )
 
+// valid reports whether r is one of the roles accepted by the API.
+func (r Role) valid() bool {
+	switch r {
+	case RoleUser, RoleAssistant, RoleSystem, RoleFunction:
+		return true
+	}
+	return false
+}
+
 // Message represents a single message in a chat conversation.
 type Message struct {
 	// Role is the author of the message. See the Role type for possible values.
@@ -22,4 +35,17 @@ type Message struct {
 
 	// Content is the textual content of the message.
 	Content string `json:"content"`
+
+	// Name is the name of the function whose result a RoleFunction message carries.
+	Name string `json:"name,omitempty"`
+
+	// FunctionCall is the function call requested by the model in an assistant message.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// FunctionsStateID links an assistant function call to its result.
+	FunctionsStateID string `json:"functions_state_id,omitempty"`
+
+	// Attachments lists the IDs of uploaded files (see Client.UploadFile)
+	// the message refers to.
+	Attachments []string `json:"attachments,omitempty"`
 }
@@ -0,0 +1,34 @@
+package gigago
+
+import "github.com/google/uuid"
+
+// sessionIDHeader is the header GigaChat uses to group requests into a conversation.
+const sessionIDHeader = "X-Session-ID"
+
+// Session identifies a multi-turn conversation with the model. Requests sent with
+// the same session ID let GigaChat reuse the context it has already processed for
+// the conversation, which makes long dialogs faster and cheaper.
+//
+// The API stays stateless with respect to messages: every request must still carry
+// the full message history, and the session ID does not replace it. Start a new
+// Session when the history is reset or edited.
+type Session struct {
+	// ID is sent in the X-Session-ID header.
+	ID string
+}
+
+// NewSession returns a Session with a new random ID.
+func NewSession() Session {
+	return Session{ID: uuid.NewString()}
+}
+
+// RequestOption returns a RequestOption attaching the session to a call.
+func (s Session) RequestOption() RequestOption {
+	return WithSession(s.ID)
+}
+
+// WithSession provides a RequestOption to send the request as part of the
+// conversation with the given session ID. See Session.
+func WithSession(id string) RequestOption {
+	return WithHeader(sessionIDHeader, id)
+}
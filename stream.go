@@ -0,0 +1,467 @@
+package gigago
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxStreamLineSize is the maximum size of a single server-sent events line.
+const maxStreamLineSize = 1 << 20
+
+// maxStreamChoices bounds the choice indices a StreamAccumulator reassembles, so
+// that a malformed index does not make it allocate a choice for every index below.
+const maxStreamChoices = 128
+
+// ChatChunk is a single incremental piece of a streamed chat completion.
+type ChatChunk struct {
+	// Choices holds the content deltas for each completion choice.
+	Choices []ChunkChoice `json:"choices"`
+
+	// Created is the Unix timestamp (seconds) of when the chunk was created.
+	Created int64 `json:"created"`
+
+	// Model specifies the exact model version used to generate the response.
+	Model string `json:"model"`
+
+	// Object is the type of the API object, typically "chat.completion".
+	Object string `json:"object"`
+
+	// Usage is the token usage of the whole completion.
+	// It is only set on the final chunk of the stream.
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// ChunkChoice is the part of a completion choice carried by a single chunk.
+type ChunkChoice struct {
+	// Delta is the new content generated since the previous chunk.
+	Delta MessageDelta `json:"delta"`
+
+	// Index is the position of the choice this delta belongs to, starting from 0.
+	Index int `json:"index"`
+
+	// FinishReason is set on the last chunk of the choice and indicates why the
+	// model stopped generating, e.g. "stop" or "length" if the output was truncated
+	// by MaxTokens. See Choice.FinishReason for the possible values.
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+// Blocked reports whether the choice was blocked by GigaChat's content moderation.
+// See Choice.Blocked.
+func (ch *ChunkChoice) Blocked() bool {
+	return ch.FinishReason == FinishReasonBlacklist
+}
+
+// MessageDelta is an incremental update to an assistant message.
+type MessageDelta struct {
+	// Role is the role of the message author. It is usually only set in the first chunk.
+	Role Role `json:"role,omitempty"`
+
+	// Content is the text generated since the previous chunk.
+	Content string `json:"content"`
+
+	// FunctionCall is the function call requested by the model, or a fragment of it.
+	// Arguments may be split across several chunks.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+
+	// FunctionsStateID links the function call to its result.
+	FunctionsStateID string `json:"functions_state_id,omitempty"`
+}
+
+// ChatStream reads a streamed chat completion chunk by chunk.
+// It is created by Client.ChatStream and is not safe for concurrent use,
+// except for Close, which may be called while Recv is pending.
+type ChatStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	ctx     context.Context
+	cancel  context.CancelFunc
+	err     error
+	usage   *UsageTracker
+	span    Span
+	// start is when ChatStream was called, and firstToken the time from start
+	// until the first chunk with content arrived, or zero before it did.
+	start      time.Time
+	firstToken time.Duration
+	metrics    Metrics
+	model      string
+	// spanOnce ends span exactly once, from either finish or Close.
+	spanOnce sync.Once
+	// closed reports whether Close has been called.
+	closed atomic.Bool
+}
+
+// ChatStream sends a chat completion request with streaming enabled and returns
+// a ChatStream to read the response from. The request itself is not modified.
+//
+// The stream is closed automatically once Recv returns an error, including io.EOF
+// at the end of the stream. To stop reading early, call Close or cancel ctx: a
+// pending Recv returns promptly with ErrStreamClosed or the context's error.
+// An access token close to expiry is refreshed first, see WithStreamRefreshBuffer.
+func (c *Client) ChatStream(ctx context.Context, req *ChatRequest, opts ...RequestOption) (*ChatStream, error) {
+	if c.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	start := time.Now()
+	req = c.withDefaultModel(req)
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	streamReq := *c.applyModelDefaults(req)
+	streamReq.Stream = true
+
+	body, err := jsonBody(&streamReq)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	header := http.Header{}
+	header.Set("Accept", "text/event-stream")
+
+	ctx, span := c.startSpan(ctx, "gigago.ChatStream", req.Model)
+	if err := c.ensureStreamToken(ctx); err != nil {
+		span.End(err)
+		cancel()
+		return nil, err
+	}
+	resp, err := c.send(ctx, http.MethodPost, chatCompletionsPath, body, c.requestHeader(http.MethodPost, header, opts))
+	if err != nil {
+		span.End(err)
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer cancel()
+		defer drainAndClose(resp.Body)
+		err := newAPIError(resp)
+		span.End(err)
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+
+	return &ChatStream{
+		body:    resp.Body,
+		scanner: scanner,
+		ctx:     ctx,
+		cancel:  cancel,
+		usage:   c.usageTracker,
+		span:    span,
+		start:   start,
+		metrics: c.metrics,
+		model:   streamReq.Model,
+	}, nil
+}
+
+// ChatStreamFunc is like ChatStream, but reads the whole stream itself and calls
+// onChunk for every chunk. It returns nil once the stream ends normally.
+//
+// If onChunk returns an error, reading stops, the stream is closed and that error
+// is returned as is, so it can be told apart from request and transport errors.
+func (c *Client) ChatStreamFunc(ctx context.Context, req *ChatRequest, onChunk func(*ChatChunk) error, opts ...RequestOption) error {
+	stream, err := c.ChatStream(ctx, req, opts...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := onChunk(chunk); err != nil {
+			return stream.finish(err)
+		}
+	}
+}
+
+// Recv returns the next chunk of the stream. It returns io.EOF once the server
+// signals the end of the stream with the "[DONE]" sentinel, and
+// io.ErrUnexpectedEOF if the connection ends before that.
+// After Recv returns an error, every subsequent call returns the same error.
+func (s *ChatStream) Recv() (*ChatChunk, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.closed.Load() {
+		return nil, s.finish(ErrStreamClosed)
+	}
+
+	data, err := s.nextEvent()
+	if err != nil {
+		return nil, s.finish(err)
+	}
+
+	if data == "[DONE]" {
+		return nil, s.finish(io.EOF)
+	}
+
+	var chunk ChatChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil, s.finish(fmt.Errorf("failed to decode stream chunk: %w", err))
+	}
+	if chunk.Usage != nil {
+		s.usage.Add(*chunk.Usage)
+	}
+	if s.firstToken == 0 && chunk.hasContent() {
+		s.firstToken = max(time.Since(s.start), 1)
+		if m, ok := s.metrics.(FirstTokenMetrics); ok {
+			m.ObserveFirstToken(s.model, s.firstToken)
+		}
+	}
+	return &chunk, nil
+}
+
+// FirstTokenLatency returns the time to first token: the wall-clock duration from
+// the call to ChatStream until Recv received the first chunk with content or a
+// function call. It returns zero before that chunk has been received. If the
+// client's Metrics implement FirstTokenMetrics, the latency is reported there too.
+func (s *ChatStream) FirstTokenLatency() time.Duration {
+	return s.firstToken
+}
+
+// hasContent reports whether any choice of the chunk carries generated content.
+func (c *ChatChunk) hasContent() bool {
+	for _, choice := range c.Choices {
+		if choice.Delta.Content != "" || choice.Delta.FunctionCall != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// nextEvent reads the next server-sent event and returns its data. An event ends
+// with a blank line; its data lines are joined with newlines, and comment lines
+// such as ": keep-alive", other fields and events without data are skipped. It
+// returns io.ErrUnexpectedEOF if the stream ends, or the read error if it fails,
+// before the event is complete.
+func (s *ChatStream) nextEvent() (string, error) {
+	var (
+		data    strings.Builder
+		hasData bool
+	)
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if hasData {
+				return data.String(), nil
+			}
+			continue
+		}
+
+		value, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		if hasData {
+			data.WriteByte('\n')
+		}
+		data.WriteString(strings.TrimSpace(value))
+		hasData = true
+	}
+
+	if s.closed.Load() {
+		return "", ErrStreamClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := s.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+// finish records err as the terminal stream error, releases the connection and
+// ends the stream's span. io.EOF ends the span as a success.
+func (s *ChatStream) finish(err error) error {
+	s.err = err
+	s.body.Close()
+	s.cancel()
+
+	if err == io.EOF {
+		s.endSpan(nil)
+	} else {
+		s.endSpan(err)
+	}
+	return err
+}
+
+// Close stops the stream: it cancels the request and closes the connection, and a
+// pending or later Recv returns ErrStreamClosed. Close returns no error and may be
+// called more than once, also after the stream has ended.
+func (s *ChatStream) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	s.cancel()
+	s.body.Close()
+	// Stopping a stream on purpose is not a failure.
+	s.endSpan(nil)
+	return nil
+}
+
+// endSpan ends the stream's span unless it has already been ended.
+func (s *ChatStream) endSpan(err error) {
+	s.spanOnce.Do(func() { s.span.End(err) })
+}
+
+// ReadAll reads the rest of the stream and assembles the chunks into a single
+// response, as if the request had been sent with Chat, using a StreamAccumulator.
+// It returns the first error other than io.EOF met while reading, or the error of
+// StreamAccumulator.Response if the assembled response is malformed.
+func (s *ChatStream) ReadAll() (*ChatResponse, error) {
+	var acc StreamAccumulator
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			return acc.Response()
+		}
+		if err != nil {
+			return nil, err
+		}
+		acc.Add(chunk)
+	}
+}
+
+// TextReader returns a reader over the generated text as it arrives: the content
+// deltas of the first choice, concatenated. The reader returns io.EOF at the end
+// of the stream and any other error of Recv as a read error. It reads from s, so
+// s must not be read by other means at the same time, e.g.
+//
+//	_, err := io.Copy(w, stream.TextReader())
+func (s *ChatStream) TextReader() io.Reader {
+	return &streamTextReader{stream: s}
+}
+
+// streamTextReader is the io.Reader returned by ChatStream.TextReader.
+type streamTextReader struct {
+	stream *ChatStream
+	// pending is the part of the last content delta not read yet.
+	pending string
+}
+
+func (r *streamTextReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for r.pending == "" {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index == 0 {
+				r.pending += choice.Delta.Content
+			}
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// StreamAccumulator assembles streamed chunks into a complete response while they
+// are being processed one by one, e.g. printed as they arrive: content deltas are
+// concatenated, function call fragments are merged, and the finish reason and
+// usage of the final chunk are kept. The zero value is ready to use.
+type StreamAccumulator struct {
+	resp ChatResponse
+	// contents collects the content of each choice. The builders are kept by
+	// pointer, since growing the slice must not copy a builder in use.
+	contents []*strings.Builder
+}
+
+// Add merges chunk into the response. Deltas are routed by their choice index, so
+// with ChatRequest.N the choices may be interleaved and arrive in any order; each
+// is reassembled on its own. Deltas with a negative index, or an index of 128 or
+// more, which no request asks for, are ignored.
+func (a *StreamAccumulator) Add(chunk *ChatChunk) {
+	if chunk.Created != 0 {
+		a.resp.Created = chunk.Created
+	}
+	if chunk.Model != "" {
+		a.resp.Model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		a.resp.Usage = *chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index < 0 || choice.Index >= maxStreamChoices {
+			continue
+		}
+		for len(a.resp.Choices) <= choice.Index {
+			a.resp.Choices = append(a.resp.Choices, Choice{Index: len(a.resp.Choices), Message: ResponseMessage{Role: RoleAssistant}})
+			a.contents = append(a.contents, &strings.Builder{})
+		}
+		mergeDelta(&a.resp.Choices[choice.Index].Message, a.contents[choice.Index], choice.Delta)
+		if choice.FinishReason != "" {
+			a.resp.Choices[choice.Index].FinishReason = choice.FinishReason
+		}
+	}
+}
+
+// Response returns the response assembled from the chunks added so far, to be
+// called once the stream has ended. It returns an error if the reassembled
+// arguments of a function call are not well-formed JSON, e.g. because fragments
+// are missing.
+func (a *StreamAccumulator) Response() (*ChatResponse, error) {
+	resp := a.resp
+	resp.Object = "chat.completion"
+	resp.Choices = slices.Clone(a.resp.Choices)
+	for i := range resp.Choices {
+		msg := &resp.Choices[i].Message
+		msg.Content = a.contents[i].String()
+		if call := msg.FunctionCall; call != nil && len(call.Arguments) > 0 && !json.Valid(call.Arguments) {
+			return nil, fmt.Errorf("function call %s in choice %d: arguments are not valid JSON", call.Name, i)
+		}
+	}
+	return &resp, nil
+}
+
+// mergeDelta adds delta to msg, collecting the content in content.
+func mergeDelta(msg *ResponseMessage, content *strings.Builder, delta MessageDelta) {
+	if delta.Role != "" {
+		msg.Role = delta.Role
+	}
+	content.WriteString(delta.Content)
+
+	if delta.FunctionCall != nil {
+		if msg.FunctionCall == nil {
+			msg.FunctionCall = &FunctionCall{}
+		}
+		if delta.FunctionCall.Name != "" {
+			msg.FunctionCall.Name = delta.FunctionCall.Name
+		}
+
+		// Arguments split across chunks arrive as JSON string fragments of the
+		// arguments object; complete arguments arrive as the object itself.
+		var fragment string
+		if err := json.Unmarshal(delta.FunctionCall.Arguments, &fragment); err == nil {
+			msg.FunctionCall.Arguments = append(msg.FunctionCall.Arguments, fragment...)
+		} else if len(delta.FunctionCall.Arguments) > 0 {
+			msg.FunctionCall.Arguments = delta.FunctionCall.Arguments
+		}
+	}
+	if delta.FunctionsStateID != "" {
+		msg.FunctionsStateID = delta.FunctionsStateID
+	}
+}
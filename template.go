@@ -0,0 +1,47 @@
+package gigago
+
+import (
+	"strings"
+	"text/template"
+)
+
+// PromptTemplate is a reusable prompt text with placeholders, written in the
+// text/template syntax, e.g. "You are a support agent for {{.Product}}.".
+//
+// Values passed to Render are inserted as plain text and are never parsed as
+// template code, so user-provided values cannot inject template actions. Note
+// that text/template performs no escaping of its own: a value is inserted
+// verbatim, so it can still contain instructions aimed at the model.
+type PromptTemplate struct {
+	tmpl *template.Template
+}
+
+// NewPromptTemplate parses text into a PromptTemplate. Rendering fails if the
+// data lacks a key the template refers to.
+func NewPromptTemplate(text string) (*PromptTemplate, error) {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &PromptTemplate{tmpl: tmpl}, nil
+}
+
+// MustPromptTemplate is like NewPromptTemplate but panics if text cannot be parsed.
+// It is intended for templates defined in package-level variables.
+func MustPromptTemplate(text string) *PromptTemplate {
+	p, err := NewPromptTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Render executes the template with data, usually a struct or a map, and returns
+// the resulting prompt.
+func (p *PromptTemplate) Render(data any) (string, error) {
+	var sb strings.Builder
+	if err := p.tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
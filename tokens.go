@@ -0,0 +1,92 @@
+package gigago
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const tokensCountPath = "/tokens/count"
+
+// TokenCount is the token count for a single input text.
+type TokenCount struct {
+	// Object is the type of the API object, typically "tokens".
+	Object string `json:"object"`
+
+	// Tokens is the number of tokens in the text.
+	Tokens int `json:"tokens"`
+
+	// Characters is the number of characters in the text.
+	Characters int `json:"characters"`
+}
+
+type tokensCountRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// CountTokens returns the number of tokens each text in input takes up for the given model.
+// Results are returned in the same order as input. An empty input returns an empty
+// result without making a request. Non-2xx responses are returned as *APIError.
+func (c *Client) CountTokens(ctx context.Context, model string, input []string, opts ...RequestOption) ([]TokenCount, error) {
+	if len(input) == 0 {
+		return []TokenCount{}, nil
+	}
+	if model == "" {
+		return nil, fmt.Errorf("model cannot be empty")
+	}
+
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx, span := c.startSpan(ctx, "gigago.CountTokens", model)
+	var resp []TokenCount
+	err := c.doRequest(ctx, http.MethodPost, tokensCountPath, tokensCountRequest{Model: model, Input: input}, &resp, opts)
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// WithModelContextLimit provides an Option to reject prompts to the given model
+// that take up more than maxTokens tokens before they are sent. Chat then counts
+// the tokens of the message contents with CountTokens, which costs an extra
+// request per call, and returns ErrContextTooLong if the sum exceeds maxTokens.
+// The count does not include function definitions, so keep some headroom.
+// It can be set for several models; by default prompts are not checked.
+func WithModelContextLimit(model string, maxTokens int) Option {
+	return func(c *Client) {
+		if c.modelContextLimits == nil {
+			c.modelContextLimits = make(map[string]int)
+		}
+		c.modelContextLimits[model] = maxTokens
+	}
+}
+
+// checkContextLimit returns ErrContextTooLong if the messages of req take up more
+// tokens than the limit set for its model with WithModelContextLimit.
+func (c *Client) checkContextLimit(ctx context.Context, req *ChatRequest, opts []RequestOption) error {
+	limit, ok := c.modelContextLimits[req.Model]
+	if !ok {
+		return nil
+	}
+
+	input := make([]string, len(req.Messages))
+	for i, msg := range req.Messages {
+		input[i] = msg.Content
+	}
+	counts, err := c.CountTokens(ctx, req.Model, input, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to count prompt tokens: %w", err)
+	}
+
+	var tokens int
+	for _, count := range counts {
+		tokens += count.Tokens
+	}
+	if tokens > limit {
+		return fmt.Errorf("%w: %d tokens, limit for %s is %d", ErrContextTooLong, tokens, req.Model, limit)
+	}
+	return nil
+}
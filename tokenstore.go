@@ -0,0 +1,112 @@
+package gigago
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TokenStore persists access tokens, so that short-lived processes such as CLI
+// tools can reuse a token across restarts instead of requesting a new one each run.
+// A store must only be shared by clients using the same credentials and scope.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Load returns the stored token, or nil and no error if there is none.
+	Load(ctx context.Context) (*Token, error)
+	// Save stores token, replacing any previously stored one.
+	Save(ctx context.Context, token *Token) error
+}
+
+// WithTokenStore provides an Option to cache access tokens in store. NewClient
+// uses the stored token instead of requesting a new one if it is still valid
+// (see WithTokenRefreshBuffer), and every newly obtained token is saved to it.
+// Errors from the store are logged and otherwise ignored.
+func WithTokenStore(store TokenStore) Option {
+	return func(c *Client) {
+		c.tokenStore = store
+	}
+}
+
+// FileTokenStore is a TokenStore keeping the token as JSON in a file
+// readable only by its owner.
+type FileTokenStore struct {
+	// Path is the path of the token file.
+	Path string
+}
+
+// NewFileTokenStore returns a FileTokenStore writing to path.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load reads the token from the file. A missing file is not an error.
+func (s *FileTokenStore) Load(ctx context.Context) (*Token, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token file %s: %w", s.Path, err)
+	}
+	return &token, nil
+}
+
+// Save writes the token to the file with 0600 permissions. The file is replaced
+// atomically, so concurrent readers never see a partially written token.
+func (s *FileTokenStore) Save(ctx context.Context, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	// os.CreateTemp creates the file with 0600 permissions.
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// initialToken returns the token a new client starts with: the stored token if
+// a token store is configured and the token is still valid, or a new token from
+// the OAuth endpoint otherwise.
+func (c *Client) initialToken(ctx context.Context) (*Token, error) {
+	if c.tokenStore != nil {
+		token, err := c.tokenStore.Load(ctx)
+		if err != nil {
+			c.logf("gigago: failed to load cached token: %v", err)
+		} else if token != nil && c.isValid(token.ExpiresAt, c.now()) {
+			return token, nil
+		}
+	}
+
+	return c.obtainToken(ctx)
+}
+
+// saveToken saves token to the token store, if one is configured.
+func (c *Client) saveToken(ctx context.Context, token *Token) {
+	if c.tokenStore == nil {
+		return
+	}
+	if err := c.tokenStore.Save(ctx, token); err != nil {
+		c.logf("gigago: failed to save token: %v", err)
+	}
+}
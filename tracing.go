@@ -0,0 +1,74 @@
+package gigago
+
+import (
+	"context"
+	"net/http"
+)
+
+// Span attribute keys set by the client.
+const (
+	attributeModel      = "gen_ai.request.model"
+	attributeEndpoint   = "gigago.endpoint"
+	attributeStatusCode = "http.response.status_code"
+)
+
+// Tracer creates spans around the client's API calls and token refreshes. The
+// otelgigago package provides an OpenTelemetry implementation, so the root
+// package does not depend on OpenTelemetry.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span called name, e.g. "gigago.Chat", as a child of the span
+	// in ctx, if any, and returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject writes the trace context of ctx into the headers of an outgoing
+	// request, so that gateways and proxies can correlate it.
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a single traced operation started by a Tracer.
+type Span interface {
+	// SetAttribute records a key-value pair on the span. value is a string or an int.
+	SetAttribute(key string, value any)
+	// End completes the span. err is the error the operation failed with, or nil.
+	End(err error)
+}
+
+// WithTracer provides an Option to trace API calls and token refreshes with tracer.
+// Each call gets a span named after the method, e.g. "gigago.Chat" or
+// "gigago.refreshToken", with the model, endpoint and response status code as
+// attributes, and the trace context is injected into outgoing request headers.
+func WithTracer(tracer Tracer) Option {
+	return func(c *Client) {
+		c.tracer = tracer
+	}
+}
+
+type spanKey struct{}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) End(error)                {}
+
+// startSpan starts a span called name if a tracer is configured, recording model
+// if it is not empty. The returned context carries the span for the requests made
+// on its behalf. The span is a no-op without a tracer.
+func (c *Client) startSpan(ctx context.Context, name, model string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := c.tracer.Start(ctx, name)
+	if model != "" {
+		span.SetAttribute(attributeModel, model)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// spanFromContext returns the span started by startSpan for ctx, or a no-op span.
+func spanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
@@ -1,11 +1,25 @@
 package gigago
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -152,6 +166,3025 @@ func TestClient_Generate(t *testing.T) {
 	}
 }
 
+// newTestClient returns a client whose OAuth endpoint always issues a valid token
+// and whose API requests are served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+
+	serverAI := httptest.NewServer(handler)
+	t.Cleanup(serverAI.Close)
+
+	serverOauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	t.Cleanup(serverOauth.Close)
+
+	opts = append([]Option{WithCustomURLAI(serverAI.URL), WithCustomURLOauth(serverOauth.URL)}, opts...)
+	client, err := NewClient(t.Context(), "testKey", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestAPIError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        int
+		contentType   string
+		body          string
+		expected      *APIError
+		expectedError string
+	}{
+		{
+			name:          "GigaChatErrorBody",
+			status:        http.StatusTooManyRequests,
+			body:          `{"code":7,"message":"Too many requests"}`,
+			expected:      &APIError{StatusCode: http.StatusTooManyRequests, Code: "7", Message: "Too many requests", Body: []byte(`{"code":7,"message":"Too many requests"}`)},
+			expectedError: "unexpected status 429: Too many requests (code 7)",
+		},
+		{
+			name:          "UnknownBody",
+			status:        http.StatusBadGateway,
+			body:          `<html>Bad Gateway</html>`,
+			expected:      &APIError{StatusCode: http.StatusBadGateway, Body: []byte(`<html>Bad Gateway</html>`)},
+			expectedError: "unexpected status 502: <html>Bad Gateway</html>",
+		},
+		{
+			name:          "ProxyErrorPage",
+			status:        http.StatusBadGateway,
+			contentType:   "text/html; charset=utf-8",
+			body:          `<html>Bad Gateway</html>`,
+			expected:      &APIError{StatusCode: http.StatusBadGateway, Body: []byte(`<html>Bad Gateway</html>`), ContentType: "text/html; charset=utf-8"},
+			expectedError: "unexpected status 502 with text/html; charset=utf-8 response, likely not from GigaChat: <html>Bad Gateway</html>",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			header := http.Header{}
+			if testCase.contentType != "" {
+				header.Set("Content-Type", testCase.contentType)
+			}
+			apiErr := newAPIError(&http.Response{StatusCode: testCase.status, Header: header, Body: io.NopCloser(strings.NewReader(testCase.body))})
+			assert.Equal(t, testCase.expected, apiErr)
+			assert.EqualError(t, apiErr, testCase.expectedError)
+		})
+	}
+
+	assert.True(t, (&APIError{StatusCode: http.StatusTooManyRequests}).IsRateLimited())
+	assert.True(t, (&APIError{StatusCode: http.StatusUnauthorized}).IsUnauthorized())
+	assert.False(t, (&APIError{StatusCode: http.StatusInternalServerError}).IsRateLimited())
+}
+
+func TestClient_ResponseDecodeError(t *testing.T) {
+	body := `{"choices":[{"message":{"role":"assistant","content":"Par`
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	})
+
+	_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	var decodeErr *ResponseDecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, chatCompletionsPath, decodeErr.Endpoint)
+	assert.Equal(t, http.StatusOK, decodeErr.StatusCode)
+	assert.Equal(t, []byte(body), decodeErr.Body)
+	assert.ErrorContains(t, err, "failed to decode response from /chat/completions (status 200): unexpected end of JSON input")
+	assert.ErrorContains(t, err, strconv.Quote(body))
+
+	long := &ResponseDecodeError{Endpoint: "/models", StatusCode: http.StatusOK, Body: bytes.Repeat([]byte("x"), 1000), Err: errors.New("invalid")}
+	assert.Equal(t, `failed to decode response from /models (status 200): invalid: body "`+strings.Repeat("x", maxDecodeErrorSnippet)+`" (truncated)`, long.Error())
+	assert.Len(t, long.Body, 1000)
+}
+
+func TestClient_ResponseContentType(t *testing.T) {
+	testCases := []struct {
+		name          string
+		contentType   string
+		body          string
+		expectedError string
+	}{
+		{name: "JSON", contentType: "application/json; charset=UTF-8", body: `{"data":[]}`},
+		{name: "PlainText", contentType: "text/plain", body: `{"data":[]}`},
+		{name: "HTML", contentType: "text/html", body: `<html>Maintenance</html>`, expectedError: `unexpected content type "text/html", want application/json`},
+		{name: "Charset", contentType: "application/json; charset=windows-1251", body: `{"data":[]}`, expectedError: `unsupported charset "windows-1251"`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", testCase.contentType)
+				_, _ = w.Write([]byte(testCase.body))
+			})
+
+			_, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}})
+			if testCase.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var decodeErr *ResponseDecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			assert.Equal(t, testCase.contentType, decodeErr.ContentType)
+			assert.ErrorContains(t, err, testCase.expectedError)
+			assert.ErrorContains(t, err, strconv.Quote(testCase.body))
+		})
+	}
+}
+
+func TestClient_Chat(t *testing.T) {
+	testCases := []struct {
+		name             string
+		request          *ChatRequest
+		mockStatus       int
+		mockResponse     string
+		expectedOutput   string
+		expectedAPIError *APIError
+		expectedError    string
+	}{
+		{
+			name: "Success",
+			request: &ChatRequest{
+				Model:       "GigaChat",
+				Messages:    []Message{{Role: RoleUser, Content: "The capital of France is"}},
+				Temperature: Ptr(0.5),
+			},
+			mockStatus:     http.StatusOK,
+			mockResponse:   `{"choices":[{"message":{"role":"assistant","content":"Paris."},"index":0,"finish_reason":"stop"}],"model":"GigaChat:1.0","usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+			expectedOutput: "Paris.",
+		},
+		{
+			name: "Failure_APIError",
+			request: &ChatRequest{
+				Model:    "Unknown",
+				Messages: []Message{{Role: RoleUser, Content: "Hi"}},
+			},
+			mockStatus:       http.StatusNotFound,
+			mockResponse:     `{"status":404,"message":"No such model"}`,
+			expectedAPIError: &APIError{StatusCode: http.StatusNotFound, Message: "No such model", Body: []byte(`{"status":404,"message":"No such model"}`), ContentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:          "Failure_EmptyMessages",
+			request:       &ChatRequest{Model: "GigaChat"},
+			expectedError: "invalid chat request: messages: must not be empty",
+		},
+		{
+			name:          "Failure_EmptyModel",
+			request:       &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "Hi"}}},
+			expectedError: "invalid chat request: model: must not be empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, chatCompletionsPath, r.URL.Path)
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+				var got ChatRequest
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+				assert.Equal(t, *testCase.request, got)
+
+				w.WriteHeader(testCase.mockStatus)
+				w.Write([]byte(testCase.mockResponse))
+			})
+
+			resp, err := client.Chat(t.Context(), testCase.request)
+			switch {
+			case testCase.expectedAPIError != nil:
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, testCase.expectedAPIError, apiErr)
+			case testCase.expectedError != "":
+				require.ErrorContains(t, err, testCase.expectedError)
+			default:
+				require.NoError(t, err)
+				require.NotEmpty(t, resp.Choices)
+				assert.Equal(t, testCase.expectedOutput, resp.Choices[0].Message.Content)
+				assert.Equal(t, "GigaChat:1.0", resp.Model)
+				assert.Equal(t, 7, resp.Usage.TotalTokens)
+			}
+		})
+	}
+}
+
+func TestChatResponse_BestChoice(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[
+			{"message":{"role":"assistant","content":"Second"},"index":1,"finish_reason":"stop"},
+			{"message":{"role":"assistant","content":"First"},"index":0,"finish_reason":"stop"},
+			{"message":{"role":"assistant","content":"Third"},"index":2,"finish_reason":"length"}
+		]}`))
+	})
+
+	n := 3
+	resp, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}, N: &n})
+	require.NoError(t, err)
+
+	require.Len(t, resp.Choices, 3)
+	for _, choice := range resp.Choices {
+		assert.Equal(t, []string{"First", "Second", "Third"}[choice.Index], choice.Message.Content)
+	}
+
+	best, err := resp.BestChoice()
+	require.NoError(t, err)
+	assert.Equal(t, "First", best.Message.Content)
+
+	_, err = (&ChatResponse{}).BestChoice()
+	require.ErrorIs(t, err, ErrNoChoices)
+}
+
+func TestClient_ChatBlocked(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Не люблю менять тему разговора, но вот сейчас тот самый случай."},"index":0,"finish_reason":"blacklist"}]}`))
+	})
+
+	resp, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, FinishReasonBlacklist, resp.Choices[0].FinishReason)
+	assert.True(t, resp.Choices[0].Blocked())
+	assert.False(t, (&Choice{FinishReason: FinishReasonStop}).Blocked())
+	assert.True(t, (&ChunkChoice{FinishReason: FinishReasonBlacklist}).Blocked())
+}
+
+func TestClient_RequestID(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "req-"+strings.TrimPrefix(r.URL.Path, "/"))
+		if r.URL.Path == modelsPath {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[],"data":[]}`))
+	})
+
+	chat, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "req-chat/completions", chat.RequestID)
+
+	embeddings, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"Hi"}})
+	require.NoError(t, err)
+	assert.Equal(t, "req-embeddings", embeddings.RequestID)
+
+	_, err = client.Models(t.Context())
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "req-models", apiErr.RequestID)
+}
+
+func TestClient_ChatAutoContinue(t *testing.T) {
+	parts := []string{"Once upon ", "a time ", "there was a gopher."}
+	var requests [][]Message
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			return
+		}
+		requests = append(requests, req.Messages)
+
+		i := len(requests) - 1
+		finishReason := FinishReasonLength
+		if i == len(parts)-1 {
+			finishReason = FinishReasonStop
+		}
+		json.NewEncoder(w).Encode(&ChatResponse{
+			Choices: []Choice{{Message: ResponseMessage{Role: RoleAssistant, Content: parts[i]}, FinishReason: finishReason}},
+			Usage:   Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+		})
+	})
+	req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Tell a story"}}, MaxTokens: 2}
+
+	resp, err := client.Chat(t.Context(), req, WithAutoContinue(5))
+	require.NoError(t, err)
+	assert.Equal(t, "Once upon a time there was a gopher.", resp.Choices[0].Message.Content)
+	assert.Equal(t, FinishReasonStop, resp.Choices[0].FinishReason)
+	assert.Equal(t, Usage{PromptTokens: 30, CompletionTokens: 6, TotalTokens: 36}, resp.Usage)
+
+	require.Len(t, requests, 3)
+	assert.Equal(t, []Message{
+		{Role: RoleUser, Content: "Tell a story"},
+		{Role: RoleAssistant, Content: "Once upon a time "},
+	}, requests[2])
+	assert.Len(t, req.Messages, 1, "request must not be modified")
+
+	// The limit stops continuing even if the answer is still truncated.
+	requests = nil
+	resp, err = client.Chat(t.Context(), req, WithAutoContinue(1))
+	require.NoError(t, err)
+	assert.Len(t, requests, 2)
+	assert.Equal(t, "Once upon a time ", resp.Choices[0].Message.Content)
+	assert.Equal(t, FinishReasonLength, resp.Choices[0].FinishReason)
+}
+
+func TestChatJSON(t *testing.T) {
+	type city struct {
+		Name       string `json:"name"`
+		Population int    `json:"population"`
+	}
+
+	testCases := []struct {
+		name          string
+		messages      []Message
+		content       string
+		expected      city
+		expectedError string
+	}{
+		{
+			name:     "Success",
+			messages: []Message{{Role: RoleUser, Content: "Describe Paris"}},
+			content:  `{"name":"Paris","population":2100000}`,
+			expected: city{Name: "Paris", Population: 2100000},
+		},
+		{
+			name:     "Success_CodeFence",
+			messages: []Message{{Role: RoleSystem, Content: "You are a geographer."}, {Role: RoleUser, Content: "Describe Paris"}},
+			content:  "```json\n{\"name\":\"Paris\",\"population\":2100000}\n```",
+			expected: city{Name: "Paris", Population: 2100000},
+		},
+		{
+			name:     "Success_SingleLineCodeFence",
+			messages: []Message{{Role: RoleUser, Content: "Describe Paris"}},
+			content:  "```json {\"name\":\"Paris\",\"population\":2100000}```",
+			expected: city{Name: "Paris", Population: 2100000},
+		},
+		{
+			name:     "Success_SingleLineCodeFenceWithoutTag",
+			messages: []Message{{Role: RoleUser, Content: "Describe Paris"}},
+			content:  "```{\"name\": \"Paris\", \"population\": 2100000}```",
+			expected: city{Name: "Paris", Population: 2100000},
+		},
+		{
+			name:          "Failure_InvalidJSON",
+			messages:      []Message{{Role: RoleUser, Content: "Describe Paris"}},
+			content:       "Paris is the capital of France.",
+			expectedError: `response is not valid JSON`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				var req ChatRequest
+				if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+					return
+				}
+				assert.Equal(t, RoleSystem, req.Messages[0].Role)
+				assert.Contains(t, req.Messages[0].Content, "valid JSON")
+				assert.Len(t, req.Messages, 2)
+				json.NewEncoder(w).Encode(&ChatResponse{Choices: []Choice{{Message: ResponseMessage{Role: RoleAssistant, Content: testCase.content}}}})
+			})
+
+			req := &ChatRequest{Model: "GigaChat", Messages: testCase.messages}
+			result, err := ChatJSON[city](t.Context(), client, req)
+			assert.Equal(t, testCase.messages, req.Messages, "request must not be modified")
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				assert.ErrorContains(t, err, testCase.content)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, result)
+		})
+	}
+}
+
+func TestClient_Complete(t *testing.T) {
+	testCases := []struct {
+		name          string
+		mockResponse  string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:         "Success",
+			mockResponse: `{"choices":[{"message":{"role":"assistant","content":"Paris."},"index":0,"finish_reason":"stop"}]}`,
+			expected:     "Paris.",
+		},
+		{
+			name:          "Failure_NoChoices",
+			mockResponse:  `{"choices":[]}`,
+			expectedError: ErrNoChoices.Error(),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				var got ChatRequest
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+				assert.Equal(t, ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "The capital of France is"}}}, got)
+				_, _ = w.Write([]byte(testCase.mockResponse))
+			})
+
+			text, err := client.Complete(t.Context(), "GigaChat", "The capital of France is")
+			if testCase.expectedError != "" {
+				require.EqualError(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, text)
+		})
+	}
+}
+
+func TestChatRequest_JSON(t *testing.T) {
+	req := ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}}
+
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"Hi"}]}`, string(data))
+
+	topP, penalty, n := 0.0, 1.1, 2
+	req.TopP, req.RepetitionPenalty, req.N = &topP, &penalty, &n
+
+	data, err = json.Marshal(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"Hi"}],"top_p":0,"repetition_penalty":1.1,"n":2}`, string(data))
+
+	req.TopP, req.RepetitionPenalty, req.N = nil, nil, nil
+	req.Temperature = Ptr(0.0)
+
+	data, err = json.Marshal(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"Hi"}],"temperature":0}`, string(data))
+
+	profanityCheck, interval := false, 0.5
+	req = ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}, ProfanityCheck: &profanityCheck, UpdateInterval: &interval}
+
+	data, err = json.Marshal(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"Hi"}],"profanity_check":false,"update_interval":0.5}`, string(data))
+}
+
+func TestChatRequest_Extra(t *testing.T) {
+	topP := 0.5
+	req := ChatRequest{
+		Model:    "GigaChat",
+		Messages: []Message{{Role: RoleUser, Content: "Hi"}},
+		TopP:     &topP,
+		Extra:    map[string]any{"top_p": 0.9, "temperature": 0.3, "attachments": []string{"file-1"}},
+	}
+
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"Hi"}],"top_p":0.5,"temperature":0.3,"attachments":["file-1"]}`, string(data))
+
+	data, err = json.Marshal(&req)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"attachments":["file-1"]`)
+
+	req.Extra = map[string]any{"bad": make(chan int)}
+	_, err = json.Marshal(req)
+	require.ErrorContains(t, err, `extra field "bad"`)
+}
+
+func TestChatBuilder(t *testing.T) {
+	builder := NewChatBuilder("GigaChat").
+		System("Be brief.").
+		User("Hi").
+		Assistant("Hello").
+		Temperature(0).
+		TopP(0.9)
+
+	first := builder.Build()
+	second := builder.User("How are you?").Model("GigaChat-Pro").Build()
+
+	assert.Equal(t, &ChatRequest{
+		Model:       "GigaChat",
+		Temperature: Ptr(0.0),
+		TopP:        Ptr(0.9),
+		Messages: []Message{
+			{Role: RoleSystem, Content: "Be brief."},
+			{Role: RoleUser, Content: "Hi"},
+			{Role: RoleAssistant, Content: "Hello"},
+		},
+	}, first)
+	assert.Equal(t, "GigaChat-Pro", second.Model)
+	assert.Len(t, second.Messages, 4)
+}
+
+func TestChatRequest_ApplyPreset(t *testing.T) {
+	req := &ChatRequest{Model: "GigaChat"}
+	req.ApplyPreset(PresetPrecise)
+	assert.Equal(t, &ChatRequest{Model: "GigaChat", Temperature: Ptr(0.1), TopP: Ptr(0.1), RepetitionPenalty: Ptr(1.0)}, req)
+
+	req = &ChatRequest{Model: "GigaChat", Temperature: Ptr(0.0)}
+	req.ApplyPreset(PresetCreative)
+	assert.Equal(t, &ChatRequest{Model: "GigaChat", Temperature: Ptr(0.0), TopP: Ptr(0.9), RepetitionPenalty: Ptr(1.1)}, req)
+
+	// Explicit builder values win regardless of the order of calls.
+	built := NewChatBuilder("GigaChat").Preset(PresetCreative).TopP(0.5).User("Hi").Build()
+	assert.Equal(t, Ptr(1.1), built.Temperature)
+	assert.Equal(t, Ptr(0.5), built.TopP)
+	assert.Equal(t, Ptr(1.1), built.RepetitionPenalty)
+}
+
+func TestClient_ModelDefaults(t *testing.T) {
+	defaultTopP, requestTopP, defaultN := 0.9, 0.5, 2
+
+	var got ChatRequest
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = ChatRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	}, WithModelDefaults("GigaChat-Pro", ChatRequest{Temperature: Ptr(0.2), MaxTokens: 100, TopP: &defaultTopP, N: &defaultN}))
+
+	messages := []Message{{Role: RoleUser, Content: "Hi"}}
+	req := &ChatRequest{Model: "GigaChat-Pro", Messages: messages, MaxTokens: 50, TopP: &requestTopP}
+	_, err := client.Chat(t.Context(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, ChatRequest{Model: "GigaChat-Pro", Messages: messages, Temperature: Ptr(0.2), MaxTokens: 50, TopP: &requestTopP, N: &defaultN}, got)
+	assert.Nil(t, req.Temperature, "request must not be modified")
+	assert.Nil(t, req.N, "request must not be modified")
+
+	_, err = client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: messages})
+	require.NoError(t, err)
+	assert.Equal(t, ChatRequest{Model: "GigaChat", Messages: messages}, got)
+}
+
+func TestClient_ResponseValidator(t *testing.T) {
+	errEmpty := errors.New("empty answer")
+	validate := func(resp *ChatResponse) error {
+		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+			return errEmpty
+		}
+		return nil
+	}
+
+	testCases := []struct {
+		name          string
+		attempts      int
+		expected      string
+		expectedError error
+		expectedCalls int32
+	}{
+		{name: "RetrySucceeds", attempts: 3, expected: "Paris.", expectedCalls: 2},
+		{name: "AttemptsExhausted", attempts: 1, expectedError: errEmpty, expectedCalls: 1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var calls int32
+			tracker := &UsageTracker{}
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				content := ""
+				if atomic.AddInt32(&calls, 1) > 1 {
+					content = "Paris."
+				}
+				fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q}}],"usage":{"total_tokens":3}}`, content)
+			}, WithResponseValidator(validate, testCase.attempts), WithUsageTracker(tracker))
+
+			resp, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "The capital of France is"}}})
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.expected, resp.Choices[0].Message.Content)
+			}
+			assert.Equal(t, testCase.expectedCalls, atomic.LoadInt32(&calls))
+			assert.Equal(t, int(testCase.expectedCalls)*3, tracker.Totals().TotalTokens, "rejected responses count towards the usage")
+		})
+	}
+}
+
+func TestClient_DefaultModel(t *testing.T) {
+	var models []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var got ChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		models = append(models, got.Model)
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	}
+	messages := []Message{{Role: RoleUser, Content: "Hi"}}
+
+	client := newTestClient(t, handler, WithDefaultModel("GigaChat-Pro"))
+	req := &ChatRequest{Messages: messages}
+	_, err := client.Chat(t.Context(), req)
+	require.NoError(t, err)
+	assert.Empty(t, req.Model, "request must not be modified")
+
+	_, err = client.Chat(t.Context(), &ChatRequest{Model: "GigaChat-Max", Messages: messages})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GigaChat-Pro", "GigaChat-Max"}, models)
+
+	client = newTestClient(t, handler)
+	_, err = client.Chat(t.Context(), &ChatRequest{Messages: messages})
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "model", validationErr.Field)
+	assert.Len(t, models, 2, "no request must be sent without a model")
+}
+
+func TestPromptTemplate(t *testing.T) {
+	tmpl := MustPromptTemplate("You are a support agent for {{.Product}}. Answer in {{.Language}}.")
+
+	prompt, err := tmpl.Render(map[string]string{"Product": "{{.Secret}}", "Language": "English"})
+	require.NoError(t, err)
+	assert.Equal(t, "You are a support agent for {{.Secret}}. Answer in English.", prompt)
+
+	_, err = tmpl.Render(map[string]string{"Product": "gigago"})
+	require.ErrorContains(t, err, "Language")
+
+	_, err = NewPromptTemplate("{{.Unclosed")
+	require.Error(t, err)
+	assert.Panics(t, func() { MustPromptTemplate("{{.Unclosed") })
+}
+
+func TestChatBuilder_SystemTemplate(t *testing.T) {
+	tmpl := MustPromptTemplate("Reply as {{.Name}}.")
+
+	builder := NewChatBuilder("GigaChat").SystemTemplate(tmpl, struct{ Name string }{"a pirate"}).User("Hi")
+	require.NoError(t, builder.Err())
+	assert.Equal(t, []Message{
+		{Role: RoleSystem, Content: "Reply as a pirate."},
+		{Role: RoleUser, Content: "Hi"},
+	}, builder.Build().Messages)
+
+	builder = NewChatBuilder("GigaChat").SystemTemplate(tmpl, map[string]string{}).User("Hi")
+	require.Error(t, builder.Err())
+	assert.Len(t, builder.Build().Messages, 1)
+}
+
+func TestConversation(t *testing.T) {
+	// Every message takes up as many tokens as its content has characters.
+	counter := func(messages []Message) int {
+		n := 0
+		for _, msg := range messages {
+			n += len(msg.Content)
+		}
+		return n
+	}
+
+	conv := NewConversation(Message{Role: RoleSystem, Content: "sys"})
+	conv.Append(Message{Role: RoleUser, Content: "aaaa"})
+	conv.Append(Message{Role: RoleAssistant, Content: "bbbb"})
+	conv.Append(Message{Role: RoleUser, Content: "cc"})
+
+	conv.TrimToTokens(20, counter)
+	assert.Len(t, conv.Messages(), 4)
+
+	conv.TrimToTokens(9, counter)
+	assert.Equal(t, []Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleAssistant, Content: "bbbb"},
+		{Role: RoleUser, Content: "cc"},
+	}, conv.Messages())
+
+	conv.TrimToTokens(1, counter)
+	assert.Equal(t, []Message{{Role: RoleSystem, Content: "sys"}}, conv.Messages())
+
+	messages := conv.Messages()
+	messages[0].Content = "changed"
+	assert.Equal(t, "sys", conv.Messages()[0].Content)
+
+	var empty Conversation
+	empty.TrimToTokens(0, counter)
+	assert.Empty(t, empty.Messages())
+}
+
+func TestConversation_TrimFunctionCall(t *testing.T) {
+	// Every message takes up as many tokens as its content has characters.
+	counter := func(messages []Message) int {
+		n := 0
+		for _, msg := range messages {
+			n += len(msg.Content)
+		}
+		return n
+	}
+	newConversation := func() *Conversation {
+		conv := NewConversation(Message{Role: RoleSystem, Content: "sys"})
+		conv.Append(Message{Role: RoleUser, Content: "aaaa"})
+		conv.Append(Message{Role: RoleAssistant, Content: "c", FunctionCall: &FunctionCall{Name: "weather", Arguments: json.RawMessage(`{}`)}})
+		conv.AddFunctionResult("weather", "ffff")
+		conv.Append(Message{Role: RoleAssistant, Content: "bb"})
+		conv.Append(Message{Role: RoleUser, Content: "cc"})
+		return conv
+	}
+
+	for maxTokens := 16; maxTokens >= 0; maxTokens-- {
+		conv := newConversation()
+		conv.TrimToTokens(maxTokens, counter)
+		messages := conv.Messages()
+		if len(messages) > 1 {
+			assert.NotEqual(t, RoleFunction, messages[1].Role, "maxTokens %d: history must not start with a function result", maxTokens)
+		}
+	}
+
+	conv := newConversation()
+	conv.TrimToTokens(11, counter)
+	assert.Equal(t, []Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleAssistant, Content: "bb"},
+		{Role: RoleUser, Content: "cc"},
+	}, conv.Messages(), "the function call and its result are evicted together")
+}
+
+func TestConversation_FunctionCall(t *testing.T) {
+	type weatherArgs struct {
+		Location string `json:"location"`
+		Unit     string `json:"unit"`
+	}
+
+	var calls int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req ChatRequest
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			return
+		}
+		if calls == 1 {
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","function_call":{"name":"get_weather","arguments":{"location":"Moscow","unit":"celsius"}},"functions_state_id":"state-1"},"index":0,"finish_reason":"function_call"}]}`))
+			return
+		}
+
+		if !assert.Len(t, req.Messages, 3) {
+			return
+		}
+		assert.Equal(t, "state-1", req.Messages[1].FunctionsStateID)
+		assert.Equal(t, Message{Role: RoleFunction, Name: "get_weather", Content: `{"temperature":-5}`}, req.Messages[2])
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"It is -5°C in Moscow."},"index":0,"finish_reason":"stop"}]}`))
+	})
+
+	conv := NewConversation(Message{Role: RoleUser, Content: "What is the weather in Moscow?"})
+	resp, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: conv.Messages()})
+	require.NoError(t, err)
+
+	call := resp.Choices[0].Message.FunctionCall
+	require.NotNil(t, call)
+	var args weatherArgs
+	require.NoError(t, call.UnmarshalArgs(&args))
+	assert.Equal(t, weatherArgs{Location: "Moscow", Unit: "celsius"}, args)
+
+	conv.Append(resp.Choices[0].Message.Message())
+	conv.AddFunctionResult(call.Name, `{"temperature":-5}`)
+	resp, err = client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: conv.Messages()})
+	require.NoError(t, err)
+	assert.Equal(t, "It is -5°C in Moscow.", resp.Choices[0].Message.Content)
+
+	encoded := &FunctionCall{Name: "get_weather", Arguments: json.RawMessage(`"{\"location\":\"Kazan\"}"`)}
+	require.NoError(t, encoded.UnmarshalArgs(&args))
+	assert.Equal(t, "Kazan", args.Location)
+
+	invalid := &FunctionCall{Name: "get_weather", Arguments: json.RawMessage(`[1]`)}
+	require.ErrorContains(t, invalid.UnmarshalArgs(&args), "get_weather")
+}
+
+func TestChatRequest_Validate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  *ChatRequest
+		expected *ValidationError
+	}{
+		{
+			name: "Valid",
+			request: &ChatRequest{Model: "GigaChat", Messages: []Message{
+				{Role: RoleSystem, Content: "Be brief."},
+				{Role: RoleUser, Content: "Hi"},
+				{Role: RoleAssistant, Content: "Hello"},
+				{Role: RoleFunction, Name: "weather", Content: "{}"},
+			}},
+		},
+		{
+			name:     "Nil",
+			expected: &ValidationError{Index: -1, Field: "messages", Message: "must not be empty"},
+		},
+		{
+			name:     "UnknownRole",
+			request:  &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser}, {Role: "bot"}}},
+			expected: &ValidationError{Index: 1, Field: "role", Message: `unknown role "bot"`},
+		},
+		{
+			name:     "SystemNotFirst",
+			request:  &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser}, {Role: RoleSystem}}},
+			expected: &ValidationError{Index: 1, Field: "role", Message: "system message must be the first message"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.request.Validate()
+			if testCase.expected == nil {
+				require.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, testCase.expected, validationErr)
+		})
+	}
+
+	assert.EqualError(t, &ValidationError{Index: 2, Field: "role", Message: "bad"}, "invalid chat request: messages[2].role: bad")
+}
+
+func TestClient_ChatStream(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var got map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		assert.Equal(t, true, got["stream"])
+		assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Par\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"is.\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+
+	req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "The capital of France is"}}}
+	stream, err := client.ChatStream(t.Context(), req)
+	require.NoError(t, err)
+	assert.False(t, req.Stream)
+
+	var content string
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		content += chunk.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "Paris.", content)
+
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestClient_ChatStreamOutlivesTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	testCases := []struct {
+		name          string
+		headerDelay   time.Duration
+		expected      string
+		expectedError bool
+	}{
+		{name: "SlowBody", expected: "Paris."},
+		{name: "SlowHeaders", headerDelay: 3 * timeout, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tc.headerDelay)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Par\"},\"index\":0}]}\n\n"))
+				w.(http.Flusher).Flush()
+				time.Sleep(3 * timeout)
+				w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"is.\"},\"index\":0}]}\n\n"))
+				w.Write([]byte("data: [DONE]\n\n"))
+			}, WithCustomTimeout(timeout))
+
+			stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "The capital of France is"}}})
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var content string
+			for {
+				chunk, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				content += chunk.Choices[0].Delta.Content
+			}
+			assert.Equal(t, tc.expected, content)
+		})
+	}
+}
+
+func TestClient_ChatStreamEvents(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": keep-alive\n\n"))
+		w.Write([]byte("\n\n"))
+		w.Write([]byte("event: message\nid: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"Par\"},\"index\":0}]}\n\n"))
+		w.Write([]byte(": keep-alive\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":\ndata: {\"content\":\"is.\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("retry: 1000\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+	resp, err := stream.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "Paris.", resp.Choices[0].Message.Content)
+
+	client = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[]}"))
+	})
+	stream, err = client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestClient_ChatStreamFunc(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Par\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"is.\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+	req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "The capital of France is"}}}
+
+	var content string
+	err := client.ChatStreamFunc(t.Context(), req, func(chunk *ChatChunk) error {
+		content += chunk.Choices[0].Delta.Content
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Paris.", content)
+
+	errStop := errors.New("stop")
+	var chunks int
+	err = client.ChatStreamFunc(t.Context(), req, func(chunk *ChatChunk) error {
+		chunks++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, chunks)
+}
+
+func TestClient_ChatStreamRefreshesToken(t *testing.T) {
+	testCases := []struct {
+		name           string
+		opts           []Option
+		expectedTokens []string
+	}{
+		{
+			name:           "NearExpiry_Refreshed",
+			expectedTokens: []string{"Bearer token-1", "Bearer token-2"},
+		},
+		{
+			name:           "Disabled",
+			opts:           []Option{WithStreamRefreshBuffer(0)},
+			expectedTokens: []string{"Bearer token-1", "Bearer token-1"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var tokenRequests atomic.Int32
+			serverOauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := tokenRequests.Add(1)
+				// The first token outlives the refresh buffer, but not the stream refresh buffer.
+				json.NewEncoder(w).Encode(&Token{AccessToken: fmt.Sprintf("token-%d", n), ExpiresAt: time.Now().Add(time.Minute).UnixMilli()})
+			}))
+			defer serverOauth.Close()
+
+			var tokens []string
+			serverAI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tokens = append(tokens, r.Header.Get("Authorization"))
+				if r.Header.Get("Accept") == "text/event-stream" {
+					w.Write([]byte("data: [DONE]\n\n"))
+					return
+				}
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			defer serverAI.Close()
+
+			opts := append([]Option{WithCustomURLOauth(serverOauth.URL), WithCustomURLAI(serverAI.URL), WithTokenRefreshBuffer(time.Second)}, testCase.opts...)
+			client, err := NewClient(t.Context(), "testKey", opts...)
+			require.NoError(t, err)
+			defer client.Close()
+
+			req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}}
+			_, err = client.Chat(t.Context(), req)
+			require.NoError(t, err)
+
+			stream, err := client.ChatStream(t.Context(), req)
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.ErrorIs(t, err, io.EOF)
+
+			assert.Equal(t, testCase.expectedTokens, tokens)
+		})
+	}
+}
+
+func TestClient_ChatStreamFinalChunk(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Once upon\"},\"index\":0}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\" a time\"},\"index\":0,\"finish_reason\":\"length\"}],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":3,\"total_tokens\":7}}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Tell me a story"}}})
+	require.NoError(t, err)
+
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Empty(t, first.Choices[0].FinishReason)
+	assert.Nil(t, first.Usage)
+
+	last, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "length", last.Choices[0].FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 4, CompletionTokens: 3, TotalTokens: 7}, last.Usage)
+
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestChatStream_ReadAll(t *testing.T) {
+	testCases := []struct {
+		name          string
+		events        []string
+		expected      *ChatResponse
+		expectedError error
+	}{
+		{
+			name: "Content",
+			events: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"Par"},"index":0}],"model":"GigaChat:1.0","created":1700000000}`,
+				`{"choices":[{"delta":{"content":"is."},"index":0,"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+				`[DONE]`,
+			},
+			expected: &ChatResponse{
+				Object:  "chat.completion",
+				Model:   "GigaChat:1.0",
+				Created: 1700000000,
+				Choices: []Choice{{Message: ResponseMessage{Role: RoleAssistant, Content: "Paris."}, FinishReason: "stop"}},
+				Usage:   Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+			},
+		},
+		{
+			name: "FunctionCallFragments",
+			events: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"","function_call":{"name":"weather","arguments":"{\"city\":"}},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"","function_call":{"arguments":"\"Paris\"}"},"functions_state_id":"state-1"},"index":0}]}`,
+				`[DONE]`,
+			},
+			expected: &ChatResponse{
+				Object: "chat.completion",
+				Choices: []Choice{{Message: ResponseMessage{
+					Role:             RoleAssistant,
+					FunctionCall:     &FunctionCall{Name: "weather", Arguments: json.RawMessage(`{"city":"Paris"}`)},
+					FunctionsStateID: "state-1",
+				}}},
+			},
+		},
+		{
+			name: "FunctionCallObject",
+			events: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"","function_call":{"name":"weather","arguments":{"city":"Paris"}}},"index":0}]}`,
+				`[DONE]`,
+			},
+			expected: &ChatResponse{
+				Object: "chat.completion",
+				Choices: []Choice{{Message: ResponseMessage{
+					Role:         RoleAssistant,
+					FunctionCall: &FunctionCall{Name: "weather", Arguments: json.RawMessage(`{"city":"Paris"}`)},
+				}}},
+			},
+		},
+		{
+			name: "UnexpectedEOF",
+			events: []string{
+				`{"choices":[{"delta":{"content":"Par"},"index":0}]}`,
+			},
+			expectedError: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for _, event := range testCase.events {
+					fmt.Fprintf(w, "data: %s\n\n", event)
+				}
+			})
+
+			stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+			require.NoError(t, err)
+
+			resp, err := stream.ReadAll()
+			if testCase.expectedError != nil {
+				require.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, resp)
+		})
+	}
+}
+
+func TestChatStream_TextReader(t *testing.T) {
+	testCases := []struct {
+		name          string
+		events        []string
+		expected      string
+		expectedError error
+	}{
+		{
+			name: "Content",
+			events: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"Par"},"index":0}]}`,
+				`{"choices":[{"delta":{"content":""},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"is is the capital of France."},"index":0,"finish_reason":"stop"}]}`,
+				`[DONE]`,
+			},
+			expected: "Paris is the capital of France.",
+		},
+		{
+			name: "UnexpectedEOF",
+			events: []string{
+				`{"choices":[{"delta":{"content":"Par"},"index":0}]}`,
+			},
+			expected:      "Par",
+			expectedError: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for _, event := range testCase.events {
+					fmt.Fprintf(w, "data: %s\n\n", event)
+				}
+			})
+
+			stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+			require.NoError(t, err)
+
+			// A small buffer makes deltas span several reads.
+			text, err := io.ReadAll(bufio.NewReaderSize(stream.TextReader(), 16))
+			assert.Equal(t, testCase.expected, string(text))
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStreamAccumulator(t *testing.T) {
+	fragments := []string{`"{\"city\":"`, `"\"Par"`, `"is\"}"`}
+
+	var acc StreamAccumulator
+	for i, fragment := range fragments {
+		chunk := &ChatChunk{Choices: []ChunkChoice{{Delta: MessageDelta{FunctionCall: &FunctionCall{Arguments: json.RawMessage(fragment)}}}}}
+		if i == 0 {
+			chunk.Choices[0].Delta.FunctionCall.Name = "weather"
+		}
+		acc.Add(chunk)
+
+		// Response fails until the arguments are complete.
+		_, err := acc.Response()
+		if i < len(fragments)-1 {
+			require.ErrorContains(t, err, "function call weather in choice 0: arguments are not valid JSON")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+	acc.Add(&ChatChunk{Choices: []ChunkChoice{{FinishReason: FinishReasonFunctionCall}}})
+
+	resp, err := acc.Response()
+	require.NoError(t, err)
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, FinishReasonFunctionCall, resp.Choices[0].FinishReason)
+	assert.Equal(t, &FunctionCall{Name: "weather", Arguments: json.RawMessage(`{"city":"Paris"}`)}, resp.Choices[0].Message.FunctionCall)
+
+	resp, err = (&StreamAccumulator{}).Response()
+	require.NoError(t, err)
+	assert.Empty(t, resp.Choices)
+
+	// A new choice showing up after another one has content must not disturb it.
+	acc = StreamAccumulator{}
+	for _, delta := range []ChunkChoice{
+		{Index: 0, Delta: MessageDelta{Content: "Hel"}},
+		{Index: 1, Delta: MessageDelta{Content: "Bon"}},
+		{Index: 0, Delta: MessageDelta{Content: "lo"}},
+		{Index: 1, Delta: MessageDelta{Content: "jour"}},
+	} {
+		acc.Add(&ChatChunk{Choices: []ChunkChoice{delta}})
+	}
+	resp, err = acc.Response()
+	require.NoError(t, err)
+	require.Len(t, resp.Choices, 2)
+	assert.Equal(t, "Hello", resp.Choices[0].Message.Content)
+	assert.Equal(t, "Bonjour", resp.Choices[1].Message.Content)
+}
+
+func TestStreamAccumulator_InterleavedChoices(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chunks   []string
+		expected []Choice
+	}{
+		{
+			name: "LaterIndexFirst",
+			chunks: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"Bon"},"index":1}]}`,
+				`{"choices":[{"delta":{"role":"assistant","content":"Hel"},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"lo!"},"index":0},{"delta":{"content":"jour"},"index":1}]}`,
+				`{"choices":[{"delta":{"content":" !"},"index":1,"finish_reason":"stop"}]}`,
+				`{"choices":[{"delta":{"content":"ignored"},"index":-1}]}`,
+				`{"choices":[{"delta":{"content":""},"index":0,"finish_reason":"length"}]}`,
+			},
+			expected: []Choice{
+				{Index: 0, Message: ResponseMessage{Role: RoleAssistant, Content: "Hello!"}, FinishReason: FinishReasonLength},
+				{Index: 1, Message: ResponseMessage{Role: RoleAssistant, Content: "Bonjour !"}, FinishReason: FinishReasonStop},
+			},
+		},
+		{
+			name: "FirstIndexWrittenBeforeSecondAppears",
+			chunks: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"Hel"},"index":0}]}`,
+				`{"choices":[{"delta":{"role":"assistant","content":"Bon"},"index":1}]}`,
+				`{"choices":[{"delta":{"content":"lo"},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"jour"},"index":1,"finish_reason":"stop"}]}`,
+				`{"choices":[{"delta":{"content":"!"},"index":0,"finish_reason":"stop"}]}`,
+			},
+			expected: []Choice{
+				{Index: 0, Message: ResponseMessage{Role: RoleAssistant, Content: "Hello!"}, FinishReason: FinishReasonStop},
+				{Index: 1, Message: ResponseMessage{Role: RoleAssistant, Content: "Bonjour"}, FinishReason: FinishReasonStop},
+			},
+		},
+		{
+			name: "GrowingOneAtATime",
+			chunks: []string{
+				`{"choices":[{"delta":{"content":"a"},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"b"},"index":1}]}`,
+				`{"choices":[{"delta":{"content":"a"},"index":0},{"delta":{"content":"b"},"index":1}]}`,
+				`{"choices":[{"delta":{"content":"c"},"index":2}]}`,
+				`{"choices":[{"delta":{"content":"c"},"index":2},{"delta":{"content":"a"},"index":0},{"delta":{"content":"b"},"index":1}]}`,
+				`{"choices":[{"delta":{"content":"d"},"index":3}]}`,
+				`{"choices":[{"delta":{"content":"a"},"index":0},{"delta":{"content":"b"},"index":1},{"delta":{"content":"c"},"index":2},{"delta":{"content":"d"},"index":3}]}`,
+				`{"choices":[{"delta":{"content":"e"},"index":4}]}`,
+				`{"choices":[{"delta":{"content":"d"},"index":3},{"delta":{"content":"c"},"index":2},{"delta":{"content":"b"},"index":1},{"delta":{"content":"a"},"index":0},{"delta":{"content":"e"},"index":4}]}`,
+			},
+			expected: []Choice{
+				{Index: 0, Message: ResponseMessage{Role: RoleAssistant, Content: "aaaaa"}},
+				{Index: 1, Message: ResponseMessage{Role: RoleAssistant, Content: "bbbbb"}},
+				{Index: 2, Message: ResponseMessage{Role: RoleAssistant, Content: "cccc"}},
+				{Index: 3, Message: ResponseMessage{Role: RoleAssistant, Content: "ddd"}},
+				{Index: 4, Message: ResponseMessage{Role: RoleAssistant, Content: "ee"}},
+			},
+		},
+		{
+			name: "HugeIndexIgnored",
+			chunks: []string{
+				`{"choices":[{"delta":{"role":"assistant","content":"Hi"},"index":0}]}`,
+				`{"choices":[{"delta":{"content":"ignored"},"index":2000000000}]}`,
+				`{"choices":[{"delta":{"content":"ignored"},"index":128}]}`,
+				`{"choices":[{"delta":{"content":"!"},"index":0,"finish_reason":"stop"}]}`,
+			},
+			expected: []Choice{
+				{Index: 0, Message: ResponseMessage{Role: RoleAssistant, Content: "Hi!"}, FinishReason: FinishReasonStop},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var acc StreamAccumulator
+			for _, data := range testCase.chunks {
+				var chunk ChatChunk
+				require.NoError(t, json.Unmarshal([]byte(data), &chunk))
+				acc.Add(&chunk)
+			}
+
+			resp, err := acc.Response()
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, resp.Choices)
+		})
+	}
+}
+
+func TestClient_ChatStreamContextCancel(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"index\":0}]}\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	stream, err := client.ChatStream(ctx, &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestChatStream_Close(t *testing.T) {
+	handlerDone := make(chan struct{})
+	tracer := &recordingTracer{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		for range 2 {
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"index\":0}]}\n\n"))
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}, WithTracer(tracer))
+
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+
+	// Close a pending Recv.
+	time.AfterFunc(20*time.Millisecond, func() { stream.Close() })
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, ErrStreamClosed)
+
+	select {
+	case <-handlerDone:
+	case <-time.After(time.Second):
+		t.Fatal("request was not cancelled by Close")
+	}
+
+	require.NoError(t, stream.Close())
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, ErrStreamClosed)
+
+	// The span is ended once by Close, not again by the Recv calls after it.
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	idx := slices.IndexFunc(tracer.spans, func(s *recordingSpan) bool { return s.name == "gigago.ChatStream" })
+	require.GreaterOrEqual(t, idx, 0)
+	assert.True(t, tracer.spans[idx].ended)
+	assert.NoError(t, tracer.spans[idx].err)
+}
+
+func TestClient_Embeddings(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, embeddingsPath, r.URL.Path)
+
+		var got EmbeddingsRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		assert.Equal(t, EmbeddingsRequest{Model: "Embeddings", Input: []string{"first", "second"}}, got)
+
+		w.Write([]byte(`{"object":"list","model":"Embeddings","data":[` +
+			`{"object":"embedding","embedding":[0.125,-1.5,3],"index":0,"usage":{"prompt_tokens":2}},` +
+			`{"object":"embedding","embedding":[0.25,0.5,-0.75],"index":1,"usage":{"prompt_tokens":3}}]}`))
+	})
+
+	resp, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"first", "second"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 2)
+	assert.Equal(t, []float32{0.125, -1.5, 3}, resp.Data[0].Embedding)
+	assert.Equal(t, []float32{0.25, 0.5, -0.75}, resp.Data[1].Embedding)
+	assert.Equal(t, 1, resp.Data[1].Index)
+	assert.Equal(t, 3, resp.Data[1].Usage.PromptTokens)
+	assert.Equal(t, 3, resp.Dimensions())
+
+	_, err = client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings"})
+	require.ErrorContains(t, err, "empty input")
+	assert.Zero(t, (&EmbeddingsResponse{}).Dimensions())
+}
+
+func TestClient_EmbeddingsDimensionMismatch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"object":"list","model":"Embeddings","data":[` +
+			`{"object":"embedding","embedding":[0.125,-1.5,3],"index":0},` +
+			`{"object":"embedding","embedding":[0.25,0.5],"index":1}]}`))
+	})
+
+	resp, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"first", "second"}})
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "embedding 1 has 2 dimensions, want 3 as the others")
+}
+
+func TestClient_EmbeddingsBatching(t *testing.T) {
+	const batchSize = 4
+	input := make([]string, batchSize*5/2)
+	for i := range input {
+		input[i] = strconv.Itoa(i)
+	}
+
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("Concurrency%d", concurrency), func(t *testing.T) {
+			var requests int32
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+
+				var req EmbeddingsRequest
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				assert.LessOrEqual(t, len(req.Input), batchSize)
+
+				// Respond in reverse order to check that the results are reordered.
+				var resp EmbeddingsResponse
+				for i := len(req.Input) - 1; i >= 0; i-- {
+					value, _ := strconv.Atoi(req.Input[i])
+					resp.Data = append(resp.Data, Embedding{Embedding: []float32{float32(value)}, Index: i})
+				}
+				json.NewEncoder(w).Encode(resp)
+			}, WithEmbeddingBatchSize(batchSize), WithEmbeddingConcurrency(concurrency))
+
+			resp, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: input})
+			require.NoError(t, err)
+
+			assert.Equal(t, int32(3), requests)
+			require.Len(t, resp.Data, len(input))
+			for i, embedding := range resp.Data {
+				assert.Equal(t, i, embedding.Index)
+				assert.Equal(t, []float32{float32(i)}, embedding.Embedding)
+			}
+		})
+	}
+}
+
+func TestClient_EmbeddingsBatchError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req EmbeddingsRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Input[0] == "c" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}, WithEmbeddingBatchSize(2))
+
+	_, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"a", "b", "c", "d", "e"}})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.ErrorContains(t, err, "embeddings batch 1")
+}
+
+func TestClient_EmbeddingsBatchCanceled(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"index":0,"embedding":[1]}]}`))
+	}
+	input := []string{"a", "b", "c"}
+
+	t.Run("BetweenBatches", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		client := newTestClient(t, handler, WithEmbeddingBatchSize(1), WithEmbeddingConcurrency(1),
+			WithResponseInterceptor(func(resp *http.Response) {
+				// Keep the first batch intact and cancel before the next one is sent.
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body = io.NopCloser(bytes.NewReader(body))
+				cancel()
+			}))
+
+		resp, err := client.Embeddings(ctx, &EmbeddingsRequest{Model: "Embeddings", Input: input})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("BeforeFirstBatch", func(t *testing.T) {
+		client := newTestClient(t, handler, WithEmbeddingBatchSize(1), WithEmbeddingConcurrency(len(input)))
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		// A free slot and the done context are both ready, so either may be selected.
+		for range 20 {
+			resp, err := client.Embeddings(ctx, &EmbeddingsRequest{Model: "Embeddings", Input: input})
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Nil(t, resp)
+		}
+	})
+
+	t.Run("MissingEmbeddings", func(t *testing.T) {
+		client := newTestClient(t, handler, WithEmbeddingBatchSize(2))
+
+		resp, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: input})
+		require.ErrorContains(t, err, "contains 2 embeddings for 3 inputs")
+		assert.Nil(t, resp)
+	})
+}
+
+func TestEmbeddingQueue(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req EmbeddingsRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		batches = append(batches, req.Input)
+		mu.Unlock()
+
+		// Reply in reverse order to check that results are matched by index.
+		var resp EmbeddingsResponse
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			n, _ := strconv.Atoi(req.Input[i])
+			resp.Data = append(resp.Data, Embedding{Index: i, Embedding: []float32{float32(n)}})
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(resp))
+	})
+
+	queue, err := client.NewEmbeddingQueue("Embeddings", WithQueueBatchSize(10), WithQueueMaxWait(20*time.Millisecond))
+	require.NoError(t, err)
+
+	const texts = 45
+	results := make([]<-chan EmbeddingResult, texts)
+	var wg sync.WaitGroup
+	for i := range texts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = queue.Submit(t.Context(), strconv.Itoa(i))
+		}()
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		got := <-result
+		require.NoError(t, got.Err)
+		assert.Equal(t, []float32{float32(i)}, got.Embedding)
+	}
+
+	mu.Lock()
+	total := 0
+	for _, batch := range batches {
+		assert.LessOrEqual(t, len(batch), 10)
+		total += len(batch)
+	}
+	assert.Equal(t, texts, total)
+	assert.Less(t, len(batches), texts, "texts must be batched")
+	mu.Unlock()
+
+	// Shutdown sends the texts still waiting.
+	pending := queue.Submit(t.Context(), "7")
+	require.NoError(t, queue.Shutdown(t.Context()))
+	got := <-pending
+	require.NoError(t, got.Err)
+	assert.Equal(t, []float32{7}, got.Embedding)
+
+	got = <-queue.Submit(t.Context(), "8")
+	assert.ErrorIs(t, got.Err, ErrEmbeddingQueueClosed)
+	require.NoError(t, queue.Close())
+}
+
+func TestEmbeddingQueue_ContextCanceled(t *testing.T) {
+	var requests int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"data":[{"index":0,"embedding":[1]}]}`))
+	})
+
+	queue, err := client.NewEmbeddingQueue("Embeddings", WithQueueMaxWait(time.Hour))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	canceled := queue.Submit(ctx, "canceled")
+	cancel()
+	live := queue.Submit(t.Context(), "live")
+	require.NoError(t, queue.Shutdown(t.Context()))
+
+	assert.ErrorIs(t, (<-canceled).Err, context.Canceled)
+	got := <-live
+	require.NoError(t, got.Err)
+	assert.Equal(t, []float32{1}, got.Embedding)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = client.NewEmbeddingQueue("")
+	assert.ErrorContains(t, err, "model cannot be empty")
+	_, err = client.NewEmbeddingQueue("Embeddings", WithQueueBatchSize(0))
+	assert.ErrorContains(t, err, "batch size must be positive")
+}
+
+func TestEmbeddingQueue_StuckRequest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		stop          func(q *EmbeddingQueue) error
+		expectedError error
+	}{
+		{
+			name: "Close",
+			stop: func(q *EmbeddingQueue) error { return q.Close() },
+		},
+		{
+			name: "ShutdownTimeout",
+			stop: func(q *EmbeddingQueue) error {
+				ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+				defer cancel()
+				return q.Shutdown(ctx)
+			},
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			received := make(chan struct{}, 1)
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				received <- struct{}{}
+				<-r.Context().Done() // the request hangs until it is aborted
+			})
+
+			queue, err := client.NewEmbeddingQueue("Embeddings", WithQueueMaxWait(time.Millisecond))
+			require.NoError(t, err)
+			stuck := queue.Submit(t.Context(), "stuck")
+			<-received
+
+			stopped := make(chan error, 1)
+			go func() { stopped <- testCase.stop(queue) }()
+			select {
+			case err := <-stopped:
+				assert.ErrorIs(t, err, testCase.expectedError)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the queue did not stop")
+			}
+			assert.ErrorIs(t, (<-stuck).Err, ErrEmbeddingQueueClosed)
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    []float32
+		want    float64
+		wantErr string
+	}{
+		{name: "identical", a: []float32{1, 2, 3}, b: []float32{1, 2, 3}, want: 1},
+		{name: "same direction", a: []float32{1, 2, 3}, b: []float32{2, 4, 6}, want: 1},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 1}, want: 0},
+		{name: "opposite", a: []float32{1, -2}, b: []float32{-1, 2}, want: -1},
+		{name: "mismatched lengths", a: []float32{1, 2}, b: []float32{1, 2, 3}, wantErr: "vector lengths differ: 2 and 3"},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 2}, wantErr: "zero vector"},
+		{name: "empty", a: nil, b: nil, wantErr: "zero vector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CosineSimilarity(tt.a, tt.b)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestEmbedding_SimilarityTo(t *testing.T) {
+	a := Embedding{Embedding: []float32{1, 1}}
+	b := Embedding{Embedding: []float32{1, 0}}
+
+	got, err := a.SimilarityTo(b)
+	require.NoError(t, err)
+	assert.InDelta(t, 1/math.Sqrt2, got, 1e-9)
+}
+
+func TestClient_ModelContextLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		wantErr   error
+		wantChats int32
+	}{
+		{name: "within limit", limit: 5, wantChats: 1},
+		{name: "exceeds limit", limit: 4, wantErr: ErrContextTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chatCalls int32
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case tokensCountPath:
+					var got tokensCountRequest
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+					assert.Equal(t, []string{"Be brief.", "Hello"}, got.Input)
+					w.Write([]byte(`[{"tokens":3},{"tokens":2}]`))
+				case chatCompletionsPath:
+					atomic.AddInt32(&chatCalls, 1)
+					w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hi"}}]}`))
+				default:
+					t.Errorf("unexpected request to %s", r.URL.Path)
+				}
+			}, WithModelContextLimit("GigaChat", tt.limit))
+
+			req := NewChatBuilder("GigaChat").System("Be brief.").User("Hello").Build()
+			_, err := client.Chat(t.Context(), req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantChats, atomic.LoadInt32(&chatCalls))
+
+			// Other models are not checked.
+			_, err = client.Chat(t.Context(), NewChatBuilder("GigaChat-Pro").User("Hello").Build())
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := NewClient(t.Context(), "key", WithModelContextLimit("GigaChat", 0))
+	assert.ErrorContains(t, err, "context limit for model \"GigaChat\" must be positive")
+}
+
+func TestClient_AutoTrim(t *testing.T) {
+	messages := []Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "aaaa"},
+		{Role: RoleAssistant, Content: "bbbb"},
+		{Role: RoleUser, Content: "cc"},
+	}
+
+	tests := []struct {
+		name      string
+		maxTokens int
+		want      []string
+		wantErr   error
+	}{
+		{name: "fits", maxTokens: 13, want: []string{"sys", "aaaa", "bbbb", "cc"}},
+		{name: "evicts oldest", maxTokens: 9, want: []string{"sys", "bbbb", "cc"}},
+		{name: "keeps system and last user message", maxTokens: 5, want: []string{"sys", "cc"}},
+		{name: "minimum does not fit", maxTokens: 4, wantErr: ErrContextTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case tokensCountPath:
+					// Every message takes up as many tokens as its content has characters.
+					var req tokensCountRequest
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					counts := make([]TokenCount, len(req.Input))
+					for i, text := range req.Input {
+						counts[i] = TokenCount{Tokens: len(text)}
+					}
+					assert.NoError(t, json.NewEncoder(w).Encode(counts))
+				case chatCompletionsPath:
+					var req ChatRequest
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+					for _, msg := range req.Messages {
+						sent = append(sent, msg.Content)
+					}
+					_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+				}
+			})
+
+			req := &ChatRequest{Model: "GigaChat", Messages: messages}
+			_, err := client.Chat(t.Context(), req, WithAutoTrim(tt.maxTokens))
+			assert.Len(t, req.Messages, 4, "request must not be modified")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, sent, "no request must be sent")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sent)
+		})
+	}
+}
+
+func TestClient_AutoTrimFunctionCalls(t *testing.T) {
+	messages := []Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "aaaa"},
+		{Role: RoleAssistant, Content: "c", FunctionCall: &FunctionCall{Name: "weather", Arguments: json.RawMessage(`{}`)}},
+		{Role: RoleFunction, Name: "weather", Content: "ffff"},
+		{Role: RoleAssistant, Content: "bb"},
+		{Role: RoleUser, Content: "cc"},
+	}
+
+	var sent []Message
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokensCountPath:
+			// Every message takes up as many tokens as its content has characters.
+			var req tokensCountRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			counts := make([]TokenCount, len(req.Input))
+			for i, text := range req.Input {
+				counts[i] = TokenCount{Tokens: len(text)}
+			}
+			assert.NoError(t, json.NewEncoder(w).Encode(counts))
+		case chatCompletionsPath:
+			var req ChatRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			sent = req.Messages
+			_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+		}
+	})
+
+	for maxTokens := 16; maxTokens >= 5; maxTokens-- {
+		sent = nil
+		_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: messages}, WithAutoTrim(maxTokens))
+		require.NoError(t, err, "maxTokens %d", maxTokens)
+		require.NotEmpty(t, sent)
+
+		head := sent[0]
+		if head.Role == RoleSystem {
+			head = sent[1]
+		}
+		assert.NotEqual(t, RoleFunction, head.Role, "maxTokens %d: history must not start with a function result", maxTokens)
+	}
+
+	sent = nil
+	_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: messages}, WithAutoTrim(11))
+	require.NoError(t, err)
+	var contents []string
+	for _, msg := range sent {
+		contents = append(contents, msg.Content)
+	}
+	assert.Equal(t, []string{"sys", "bb", "cc"}, contents, "the function call and its result are evicted together")
+}
+
+func TestClient_Do(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "call", r.Header.Get("X-Request-ID"))
+		switch r.URL.Path {
+		case "/balance":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			body, _ := io.ReadAll(r.Body)
+			assert.JSONEq(t, `{"usage":"GigaChat"}`, string(body))
+			_, _ = w.Write([]byte(`{"balance":[{"usage":"GigaChat","value":1000}]}`))
+		default:
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Empty(t, r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	resp, err := client.Do(t.Context(), http.MethodPost, "/balance", map[string]string{"usage": "GigaChat"}, WithHeader("X-Request-ID", "call"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"balance":[{"usage":"GigaChat","value":1000}]}`, string(body))
+
+	resp, err = client.Do(t.Context(), http.MethodGet, "unknown", nil, WithHeader("X-Request-ID", "call"))
+	require.NoError(t, err, "non-2xx responses are returned as they are")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "/unknown", resp.Request.URL.Path)
+}
+
+func TestClient_Models(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, modelsPath, r.URL.Path)
+		w.Write([]byte(`{"object":"list","data":[` +
+			`{"id":"GigaChat","object":"model","owned_by":"salutedevices","type":"chat"},` +
+			`{"id":"Embeddings","object":"model","owned_by":"salutedevices","type":"embedder"}]}`))
+	})
+
+	models, err := client.Models(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Model{
+		{ID: "GigaChat", Object: "model", OwnedBy: "salutedevices", Type: "chat"},
+		{ID: "Embeddings", Object: "model", OwnedBy: "salutedevices", Type: "embedder"},
+	}, models)
+}
+
+func TestClient_CountTokens(t *testing.T) {
+	var callCount int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		assert.Equal(t, tokensCountPath, r.URL.Path)
+
+		var got tokensCountRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		assert.Equal(t, tokensCountRequest{Model: "GigaChat", Input: []string{"Hello", "How are you?"}}, got)
+
+		w.Write([]byte(`[{"object":"tokens","tokens":1,"characters":5},{"object":"tokens","tokens":4,"characters":12}]`))
+	})
+
+	counts, err := client.CountTokens(t.Context(), "GigaChat", []string{"Hello", "How are you?"})
+	require.NoError(t, err)
+	assert.Equal(t, []TokenCount{
+		{Object: "tokens", Tokens: 1, Characters: 5},
+		{Object: "tokens", Tokens: 4, Characters: 12},
+	}, counts)
+
+	counts, err = client.CountTokens(t.Context(), "GigaChat", nil)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callCount))
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClient_CustomClientUsedForAllRequests(t *testing.T) {
+	var hosts []string
+	var mu sync.Mutex
+
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			hosts = append(hosts, r.URL.Host)
+			mu.Unlock()
+
+			body := `{"object":"list","data":[]}`
+			if r.URL.Host == "oauth.test" {
+				body = fmt.Sprintf(`{"access_token":"token","expires_at":%d}`, time.Now().Add(time.Hour).UnixMilli())
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	client, err := NewClient(t.Context(), "testKey",
+		WithCustomClient(httpClient),
+		WithCustomURLOauth("http://oauth.test/api/v2/oauth"),
+		WithCustomURLAI("http://api.test/api/v1"),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oauth.test", "api.test"}, hosts)
+}
+
+func TestClient_UserAgent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{name: "Default", expected: "gigago/" + Version},
+		{name: "Custom", opts: []Option{WithUserAgent("myapp/1.2 gigago/" + Version)}, expected: "myapp/1.2 gigago/" + Version},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			agents := map[string]string{}
+			var mu sync.Mutex
+
+			httpClient := &http.Client{
+				Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					mu.Lock()
+					agents[r.URL.Host] = r.Header.Get("User-Agent")
+					mu.Unlock()
+
+					body := `{"choices":[]}`
+					if r.URL.Host == "oauth.test" {
+						body = fmt.Sprintf(`{"access_token":"token","expires_at":%d}`, time.Now().Add(time.Hour).UnixMilli())
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+				}),
+			}
+
+			opts := append([]Option{
+				WithCustomClient(httpClient),
+				WithCustomURLOauth("http://oauth.test/api/v2/oauth"),
+				WithCustomURLAI("http://api.test/api/v1"),
+			}, testCase.opts...)
+			client, err := NewClient(t.Context(), "testKey", opts...)
+			require.NoError(t, err)
+			defer client.Close()
+
+			_, err = client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"oauth.test": testCase.expected, "api.test": testCase.expected}, agents)
+		})
+	}
+}
+
+func TestClient_Headers(t *testing.T) {
+	var header http.Header
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}, WithDefaultHeaders(map[string]string{"X-Client-ID": "client", "X-Request-ID": "default"}))
+
+	_, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}},
+		WithHeader("X-Request-ID", "call"),
+		WithHeader("Authorization", "Bearer forged"),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "client", header.Get("X-Client-ID"))
+	assert.Equal(t, "call", header.Get("X-Request-ID"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+}
+
+func TestWithServerTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{timeout: 30 * time.Second, want: "30"},
+		{timeout: 1500 * time.Millisecond, want: "2"},
+		{timeout: time.Millisecond, want: "1"},
+		{timeout: 2 * time.Minute, want: "120"},
+		{timeout: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			var got string
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("X-Request-Timeout")
+				_, _ = w.Write([]byte(`{"data":[]}`))
+			})
+
+			_, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}}, WithServerTimeout(tt.timeout))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClient_IdempotencyKey(t *testing.T) {
+	var (
+		keys     []string
+		requests int
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		requests++
+		if requests%2 == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}
+	req := &EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}}
+
+	client := newTestClient(t, handler, WithIdempotencyHeader("idempotency-key"), WithRateLimitRetry(1))
+	_, err := client.Embeddings(t.Context(), req)
+	require.NoError(t, err)
+	_, err = client.Embeddings(t.Context(), req)
+	require.NoError(t, err)
+	_, err = client.Embeddings(t.Context(), req, WithIdempotencyKey("fixed"))
+	require.NoError(t, err)
+
+	require.Len(t, keys, 6)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1], "the retry must reuse the key")
+	assert.Equal(t, keys[2], keys[3])
+	assert.NotEqual(t, keys[0], keys[2], "every call must get its own key")
+	assert.Equal(t, []string{"fixed", "fixed"}, keys[4:])
+
+	keys = nil
+	client = newTestClient(t, handler, WithRateLimitRetry(1))
+	_, err = client.Embeddings(t.Context(), req, WithIdempotencyKey("fixed"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", ""}, keys)
+}
+
+func TestClient_Session(t *testing.T) {
+	var sessionIDs []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sessionIDs = append(sessionIDs, r.Header.Get("X-Session-ID"))
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	})
+
+	session := NewSession()
+	require.NotEmpty(t, session.ID)
+	assert.NotEqual(t, session.ID, NewSession().ID)
+
+	req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "hi"}}}
+	for range 2 {
+		_, err := client.Chat(t.Context(), req, session.RequestOption())
+		require.NoError(t, err)
+	}
+	_, err := client.Chat(t.Context(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{session.ID, session.ID, ""}, sessionIDs)
+}
+
+func TestClient_Interceptors(t *testing.T) {
+	var requests, responses []string
+	var bodies []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "debug", r.Header.Get("X-Debug"))
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	},
+		WithRequestInterceptor(func(req *http.Request) {
+			requests = append(requests, req.Header.Get("Authorization"))
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				require.NoError(t, err)
+				data, _ := io.ReadAll(body)
+				bodies = append(bodies, string(data))
+			}
+		}),
+		WithRequestInterceptor(func(req *http.Request) {
+			req.Header.Set("X-Debug", "debug")
+		}),
+		WithResponseInterceptor(func(resp *http.Response) {
+			responses = append(responses, resp.Status)
+		}),
+	)
+
+	_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Basic testKey", "Bearer token"}, requests)
+	assert.Equal(t, []string{"200 OK", "200 OK"}, responses)
+	require.Len(t, bodies, 2)
+	assert.Equal(t, "scope=GIGACHAT_API_PERS", bodies[0])
+	assert.JSONEq(t, `{"model":"GigaChat","messages":[{"role":"user","content":"hi"}]}`, bodies[1])
+}
+
+type recordingMetrics struct {
+	mu           sync.Mutex
+	observations []string
+}
+
+func (m *recordingMetrics) ObserveRequest(endpoint string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observations = append(m.observations, fmt.Sprintf("%s %d", endpoint, status))
+}
+
+func TestClient_Metrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == modelsPath {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("image"))
+	}, WithMetrics(metrics))
+
+	_, err := client.Models(t.Context())
+	require.Error(t, err)
+
+	content, _, err := client.DownloadFile(t.Context(), "file-1")
+	require.NoError(t, err)
+	content.Close()
+
+	assert.Equal(t, []string{"oauth 200", "/models 500", "/files/{id}/content 200"}, metrics.observations)
+
+	client.baseURLAI = "http://127.0.0.1:0"
+	_, err = client.Models(t.Context())
+	require.Error(t, err)
+	assert.Equal(t, "/models 0", metrics.observations[len(metrics.observations)-1])
+}
+
+type firstTokenMetrics struct {
+	recordingMetrics
+	firstTokens []time.Duration
+}
+
+func (m *firstTokenMetrics) ObserveFirstToken(model string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observations = append(m.observations, "first token "+model)
+	m.firstTokens = append(m.firstTokens, d)
+}
+
+func TestChatStream_FirstTokenLatency(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	metrics := &firstTokenMetrics{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"\"},\"index\":0}]}\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"index\":0}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"!\"},\"index\":0}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}, WithMetrics(metrics))
+
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Zero(t, stream.FirstTokenLatency(), "a chunk without content is not the first token")
+
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	latency := stream.FirstTokenLatency()
+	assert.GreaterOrEqual(t, latency, delay)
+	assert.Less(t, latency, 5*time.Second)
+
+	_, err = stream.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, latency, stream.FirstTokenLatency(), "later chunks must not change the latency")
+
+	assert.Equal(t, []string{"oauth 200", "/chat/completions 200", "first token GigaChat"}, metrics.observations)
+	assert.Equal(t, []time.Duration{latency}, metrics.firstTokens)
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	name       string
+	attributes map[string]any
+	ended      bool
+	err        error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordingSpan{name: name, attributes: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (t *recordingTracer) Inject(ctx context.Context, header http.Header) {
+	header.Set("Traceparent", "00-trace-span-01")
+}
+
+func (s *recordingSpan) SetAttribute(key string, value any) {
+	s.attributes[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func TestClient_Tracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "00-trace-span-01", r.Header.Get("Traceparent"))
+		if r.URL.Path == embeddingsPath {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[]}\n\ndata: [DONE]\n\n"))
+	}, WithTracer(tracer))
+
+	client.accessToken.ExpiresAt = time.Now().UnixMilli()
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+	}
+
+	_, err = client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"hi"}})
+	require.Error(t, err)
+
+	require.Len(t, tracer.spans, 4)
+	initial, streamSpan, refresh, embeddings := tracer.spans[0], tracer.spans[1], tracer.spans[2], tracer.spans[3]
+
+	assert.Equal(t, "gigago.refreshToken", initial.name)
+	assert.True(t, initial.ended)
+	assert.NoError(t, initial.err)
+
+	assert.Equal(t, "gigago.ChatStream", streamSpan.name)
+	assert.Equal(t, map[string]any{attributeModel: "GigaChat", attributeEndpoint: chatCompletionsPath, attributeStatusCode: http.StatusOK}, streamSpan.attributes)
+	assert.True(t, streamSpan.ended)
+	assert.NoError(t, streamSpan.err)
+
+	assert.Equal(t, "gigago.refreshToken", refresh.name)
+	assert.Equal(t, map[string]any{attributeEndpoint: oauthEndpoint, attributeStatusCode: http.StatusOK}, refresh.attributes)
+	assert.True(t, refresh.ended)
+
+	assert.Equal(t, "gigago.Embeddings", embeddings.name)
+	assert.Equal(t, http.StatusBadRequest, embeddings.attributes[attributeStatusCode])
+	var apiErr *APIError
+	assert.ErrorAs(t, embeddings.err, &apiErr)
+}
+
+func TestClient_Proxy(t *testing.T) {
+	var hosts []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		mu.Unlock()
+
+		if r.Host == "oauth.test" {
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	client, err := NewClient(t.Context(), "testKey",
+		WithCustomURLOauth("http://oauth.test/api/v2/oauth"),
+		WithCustomURLAI("http://api.test/api/v1"),
+		WithProxy(proxyURL),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oauth.test", "api.test"}, hosts)
+}
+
+func TestClient_ConnectionPool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	opts := []Option{WithCustomURLOauth(server.URL), WithMaxIdleConnsPerHost(200), WithIdleConnTimeout(5 * time.Minute)}
+	client, err := NewClient(t.Context(), "testKey", opts...)
+	require.NoError(t, err)
+	defer client.Close()
+
+	transport := client.httpClient.Transport.(*http.Transport)
+	assert.Equal(t, 200, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 200, transport.MaxIdleConns)
+	assert.Equal(t, 5*time.Minute, transport.IdleConnTimeout)
+
+	// A custom client's transport is left alone.
+	custom := &http.Transport{}
+	client, err = NewClient(t.Context(), "testKey", append([]Option{WithCustomClient(&http.Client{Transport: custom})}, opts...)...)
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Zero(t, custom.MaxIdleConnsPerHost)
+	assert.Zero(t, custom.IdleConnTimeout)
+}
+
+func TestClient_DefaultProxyFromEnvironment(t *testing.T) {
+	for _, client := range []*Client{{}, {httpClient: &http.Client{}}} {
+		assert.NotNil(t, client.transport().Proxy)
+	}
+}
+
+// trackedBody records whether a response body was read to the end and closed.
+type trackedBody struct {
+	io.Reader
+	drained, closed bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.drained = true
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestClient_ResponseBodiesDrained(t *testing.T) {
+	testCases := []struct {
+		name      string
+		responses []*http.Response
+		call      func(c *Client) error
+	}{
+		{
+			name: "TrailingData",
+			responses: []*http.Response{
+				{StatusCode: http.StatusOK, Body: &trackedBody{Reader: strings.NewReader(`{"data":[]}` + "\n\n\n")}},
+			},
+			call: func(c *Client) error {
+				_, err := c.Models(t.Context())
+				return err
+			},
+		},
+		{
+			name: "RetryAfterUnauthorized",
+			responses: []*http.Response{
+				{StatusCode: http.StatusUnauthorized, Body: &trackedBody{Reader: strings.NewReader(`{"message":"expired"}`)}},
+				{StatusCode: http.StatusOK, Body: &trackedBody{Reader: strings.NewReader(`{"data":[]}`)}},
+			},
+			call: func(c *Client) error {
+				_, err := c.Models(t.Context())
+				return err
+			},
+		},
+		{
+			name: "DecodeError",
+			responses: []*http.Response{
+				{StatusCode: http.StatusOK, Body: &trackedBody{Reader: strings.NewReader(`not json, and more`)}},
+			},
+			call: func(c *Client) error {
+				_, err := c.Models(t.Context())
+				require.Error(t, err)
+				return nil
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			responses := testCase.responses
+			client := &Client{
+				baseURLAI:   "http://api.test",
+				accessToken: &Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()},
+				httpClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					resp := responses[0]
+					responses = responses[1:]
+					return resp, nil
+				})},
+			}
+			client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+				return &Token{AccessToken: "token-2", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+			}
+
+			require.NoError(t, testCase.call(client))
+			for i, resp := range testCase.responses {
+				body := resp.Body.(*trackedBody)
+				assert.True(t, body.drained, "response %d not drained", i)
+				assert.True(t, body.closed, "response %d not closed", i)
+			}
+		})
+	}
+}
+
+func TestClient_MaxResponseBytes(t *testing.T) {
+	models := `{"object":"list","data":[{"id":"GigaChat","object":"model","owned_by":"salutedevices"}]}`
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == chatCompletionsPath {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for range 10 {
+				w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"},\"index\":0}]}\n\n"))
+			}
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(models))
+	}, WithMaxResponseBytes(int64(len(models))))
+
+	_, err := client.Models(t.Context())
+	require.NoError(t, err)
+
+	client.maxResponseBytes--
+	_, err = client.Models(t.Context())
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	stream, err := client.ChatStream(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+	_, err = stream.ReadAll()
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+}
+
+func TestClient_Compression(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding")) {
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"object":"list","data":[{"object":"embedding","embedding":[0.5,1],"index":0}]}`))
+		zw.Close()
+	}, WithCompression())
+
+	resp, err := client.Embeddings(t.Context(), &EmbeddingsRequest{Model: "Embeddings", Input: []string{"Hi"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Data, 1)
+	assert.Equal(t, []float32{0.5, 1}, resp.Data[0].Embedding)
+}
+
+func TestGzipBody(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	underlying := &trackedBody{Reader: &buf}
+	body := &gzipBody{body: underlying}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	require.NoError(t, body.Close())
+	assert.True(t, underlying.closed)
+
+	_, err = io.ReadAll(&gzipBody{body: io.NopCloser(strings.NewReader("definitely not gzip"))})
+	require.ErrorIs(t, err, gzip.ErrHeader)
+}
+
+func TestClient_TLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth" {
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(t.Context(), "testKey", WithCustomURLOauth(server.URL+"/oauth"))
+	require.ErrorContains(t, err, "certificate")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	client, err := NewClient(t.Context(), "testKey",
+		WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}),
+		WithRootCAs(pool),
+		WithCustomURLOauth(server.URL+"/oauth"),
+		WithCustomURLAI(server.URL),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, uint16(tls.VersionTLS12), client.tlsConfig().MinVersion)
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+}
+
+func TestClient_RequestTimeout(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}, WithRequestTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	_, err := client.Models(t.Context())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Hour)
+	defer cancel()
+	got, _ := client.withRequestTimeout(ctx)
+	assert.Equal(t, ctx, got, "an existing deadline must not be replaced")
+
+	got, cancelTimeout := client.withRequestTimeout(t.Context())
+	defer cancelTimeout()
+	deadline, ok := got.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(20*time.Millisecond), deadline, 20*time.Millisecond)
+}
+
+func TestClient_RequestTimeoutCoversAttempts(t *testing.T) {
+	var calls int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":""}}]}`))
+	},
+		WithRequestTimeout(100*time.Millisecond),
+		WithResponseValidator(func(*ChatResponse) error { return errors.New("empty answer") }, 100),
+	)
+
+	start := time.Now()
+	_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "all attempts must share the request timeout")
+	assert.Less(t, atomic.LoadInt32(&calls), int32(10))
+}
+
+func TestClient_RetryOnUnauthorized(t *testing.T) {
+	testCases := []struct {
+		name           string
+		statuses       []int
+		expectedTokens []string
+		expectedStatus int
+	}{
+		{
+			name:           "Success_AfterRefresh",
+			statuses:       []int{http.StatusUnauthorized, http.StatusOK},
+			expectedTokens: []string{"Bearer revoked", "Bearer refreshed"},
+		},
+		{
+			name:           "Failure_RetriedOnlyOnce",
+			statuses:       []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusOK},
+			expectedTokens: []string{"Bearer revoked", "Bearer refreshed"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var tokens []string
+			client := &Client{
+				baseURLAI:     "http://api.test",
+				refreshBuffer: defaultTokenRefreshBuffer,
+				accessToken:   &Token{AccessToken: "revoked", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()},
+				httpClient: &http.Client{
+					Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+						tokens = append(tokens, r.Header.Get("Authorization"))
+						return &http.Response{
+							StatusCode: testCase.statuses[len(tokens)-1],
+							Body:       io.NopCloser(strings.NewReader(`{"object":"list","data":[]}`)),
+						}, nil
+					}),
+				},
+			}
+			client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+				return &Token{AccessToken: "refreshed", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+			}
+
+			_, err := client.Models(t.Context())
+			if testCase.expectedStatus != 0 {
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, testCase.expectedStatus, apiErr.StatusCode)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, testCase.expectedTokens, tokens)
+		})
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}, WithRateLimit(20, 1))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := client.Models(t.Context())
+		require.NoError(t, err)
+	}
+	// The first call uses the burst, the other three wait 50ms each.
+	assert.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := client.Models(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClient_MaxConcurrentRequests(t *testing.T) {
+	const limit = 3
+
+	var inFlight, maxInFlight atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			current := maxInFlight.Load()
+			if n <= current || maxInFlight.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"data":[]}`))
+	}, WithMaxConcurrentRequests(limit))
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Models(t.Context())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(limit))
+	assert.Positive(t, maxInFlight.Load())
+
+	// A call waiting for a slot gives up when its context is done.
+	for range limit {
+		client.requestSlots <- struct{}{}
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.Models(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		value    string
+		expected time.Duration
+	}{
+		{name: "Seconds", value: "3", expected: 3 * time.Second},
+		{name: "HTTPDate", value: now.Add(5 * time.Second).Format(http.TimeFormat), expected: 5 * time.Second},
+		{name: "DateInPast", value: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0},
+		{name: "Missing", value: "", expected: defaultRetryAfter},
+		{name: "Malformed", value: "soon", expected: defaultRetryAfter},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			header := http.Header{}
+			if testCase.value != "" {
+				header.Set("Retry-After", testCase.value)
+			}
+			assert.Equal(t, testCase.expected, retryAfter(header, now))
+		})
+	}
+}
+
+func TestClient_RateLimitRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		limitedCalls  int32
+		expectedCalls int32
+		expectedError bool
+	}{
+		{
+			name:          "Success_AfterRetries",
+			limitedCalls:  2,
+			expectedCalls: 3,
+		},
+		{
+			name:          "Failure_RetriesExhausted",
+			limitedCalls:  10,
+			expectedCalls: 3,
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var callCount int32
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&callCount, 1) <= testCase.limitedCalls {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte(`{"status":429,"message":"Too many requests"}`))
+					return
+				}
+				w.Write([]byte(`{"object":"list","data":[]}`))
+			}, WithRateLimitRetry(2))
+
+			_, err := client.Models(t.Context())
+			if testCase.expectedError {
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.True(t, apiErr.IsRateLimited())
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, testCase.expectedCalls, atomic.LoadInt32(&callCount))
+		})
+	}
+}
+
+func TestClient_RetryableStatusCodes(t *testing.T) {
+	testCases := []struct {
+		name          string
+		opts          []Option
+		expectedCalls int32
+	}{
+		{
+			name:          "Default_Retried",
+			expectedCalls: 2,
+		},
+		{
+			name:          "Removed_NotRetried",
+			opts:          []Option{WithRetryableStatusCodes(http.StatusTooManyRequests, http.StatusServiceUnavailable)},
+			expectedCalls: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var callCount int32
+			opts := append([]Option{WithRateLimitRetry(1)}, testCase.opts...)
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&callCount, 1) == 1 {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte(`{"object":"list","data":[]}`))
+			}, opts...)
+
+			_, err := client.Models(t.Context())
+			if testCase.expectedCalls == 1 {
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusInternalServerError, apiErr.StatusCode)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, testCase.expectedCalls, atomic.LoadInt32(&callCount))
+		})
+	}
+}
+
+func TestFunctionCallMode_JSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     FunctionCallMode
+		expected string
+	}{
+		{name: "Auto", mode: FunctionCallMode{Mode: FunctionCallAuto}, expected: `"auto"`},
+		{name: "None", mode: FunctionCallMode{Mode: FunctionCallNone}, expected: `"none"`},
+		{name: "Named", mode: FunctionCallMode{Name: "weather"}, expected: `{"name":"weather"}`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := json.Marshal(testCase.mode)
+			require.NoError(t, err)
+			assert.JSONEq(t, testCase.expected, string(data))
+
+			var decoded FunctionCallMode
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, testCase.mode, decoded)
+		})
+	}
+
+	data, err := json.Marshal(ChatRequest{Model: "GigaChat"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "function_call")
+}
+
+func TestClient_ChatFunctionCalling(t *testing.T) {
+	weather := FunctionDef{
+		Name:        "weather",
+		Description: "Returns the weather forecast for a city",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}}}`),
+	}
+
+	var requests []map[string]any
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var got map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		requests = append(requests, got)
+
+		if len(requests) == 1 {
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","function_call":{"name":"weather","arguments":{"city":"Paris"}},"functions_state_id":"state-1"},"index":0,"finish_reason":"function_call"}]}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Sunny in Paris."},"index":0,"finish_reason":"stop"}]}`))
+	})
+
+	messages := []Message{{Role: RoleUser, Content: "What's the weather in Paris?"}}
+	resp, err := client.Chat(t.Context(), &ChatRequest{
+		Model:        "GigaChat",
+		Messages:     messages,
+		Functions:    []FunctionDef{weather},
+		FunctionCall: FunctionCallMode{Mode: FunctionCallAuto},
+	})
+	require.NoError(t, err)
+
+	choice := resp.Choices[0]
+	require.Equal(t, "function_call", choice.FinishReason)
+	require.NotNil(t, choice.Message.FunctionCall)
+	assert.Equal(t, "weather", choice.Message.FunctionCall.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(choice.Message.FunctionCall.Arguments))
+
+	messages = append(messages, choice.Message.Message(), Message{Role: RoleFunction, Name: "weather", Content: `{"forecast":"sunny"}`})
+	resp, err = client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: messages, Functions: []FunctionDef{weather}})
+	require.NoError(t, err)
+	assert.Equal(t, "Sunny in Paris.", resp.Choices[0].Message.Content)
+
+	require.Len(t, requests, 2)
+	assert.Equal(t, "auto", requests[0]["function_call"])
+	assert.Len(t, requests[0]["functions"], 1)
+	sent := requests[1]["messages"].([]any)
+	require.Len(t, sent, 3)
+	assert.Equal(t, "state-1", sent[1].(map[string]any)["functions_state_id"])
+	assert.Equal(t, map[string]any{"role": "function", "name": "weather", "content": `{"forecast":"sunny"}`}, sent[2])
+}
+
+func TestClient_UploadFile(t *testing.T) {
+	content := strings.Repeat("image-bytes", 10000)
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, filesPath, r.URL.Path)
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+
+		file, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, _ := io.ReadAll(file)
+		assert.Equal(t, content, string(data))
+		assert.Equal(t, "cat.png", header.Filename)
+		assert.Equal(t, "image/png", header.Header.Get("Content-Type"))
+		assert.Equal(t, FilePurposeGeneral, r.FormValue("purpose"))
+
+		w.Write([]byte(`{"id":"file-1","object":"file","bytes":110000,"created_at":1700000000,"filename":"cat.png","purpose":"general","access_policy":"private"}`))
+	})
+
+	file, err := client.UploadFile(t.Context(), "cat.png", strings.NewReader(content), FilePurposeGeneral)
+	require.NoError(t, err)
+	assert.Equal(t, &File{ID: "file-1", Object: "file", Bytes: 110000, CreatedAt: 1700000000, Filename: "cat.png", Purpose: "general", AccessPolicy: "private"}, file)
+
+	data, err := json.Marshal(Message{Role: RoleUser, Content: "What is on the picture?", Attachments: []string{file.ID}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":"What is on the picture?","attachments":["file-1"]}`, string(data))
+}
+
+func TestClient_UploadFileNotReplayedOnUnauthorized(t *testing.T) {
+	var callCount int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := client.UploadFile(t.Context(), "notes.txt", strings.NewReader("hello"), FilePurposeGeneral)
+	require.ErrorContains(t, err, "cannot be replayed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callCount))
+}
+
+func TestExtractImageIDs(t *testing.T) {
+	resp := &ChatResponse{Choices: []Choice{
+		{Message: ResponseMessage{Content: `Here is a cat: <img src="b3f5a2c1-0000" fuse="true"/> and a dog <img src="c4a6b3d2-1111"/>`}},
+		{Message: ResponseMessage{Content: "No images here."}},
+	}}
+
+	assert.Equal(t, []string{"b3f5a2c1-0000", "c4a6b3d2-1111"}, ExtractImageIDs(resp))
+	assert.Empty(t, ExtractImageIDs(&ChatResponse{}))
+	assert.Empty(t, ExtractImageIDs(nil))
+}
+
+func TestClient_DownloadFile(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != filesPath+"/img-1/content" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status":404,"message":"File not found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg-bytes"))
+	}, WithRequestTimeout(time.Minute))
+
+	content, mimeType, err := client.DownloadFile(t.Context(), "img-1")
+	require.NoError(t, err)
+	defer content.Close()
+
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg-bytes", string(data))
+	assert.Equal(t, "image/jpeg", mimeType)
+
+	_, _, err = client.DownloadFile(t.Context(), "missing")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "File not found", apiErr.Message)
+}
+
+func TestUsageTracker(t *testing.T) {
+	var tracker UsageTracker
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.Add(Usage{PromptTokens: 3, CompletionTokens: 2, PrecachedPromptTokens: 1, TotalTokens: 4})
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, Usage{PromptTokens: 150, CompletionTokens: 100, PrecachedPromptTokens: 50, TotalTokens: 200}, tracker.Totals())
+	assert.Equal(t, 50, tracker.Requests())
+
+	var nilTracker *UsageTracker
+	assert.NotPanics(t, func() { nilTracker.Add(Usage{TotalTokens: 1}) })
+}
+
+func TestClient_WithUsageTracker(t *testing.T) {
+	tracker := &UsageTracker{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var got map[string]any
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["stream"] == true {
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"index\":0}]}\n\n"))
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"\"},\"index\":0}],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":1,\"total_tokens\":5}}\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hi"}}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`))
+	}, WithUsageTracker(tracker))
+
+	req := &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}}
+	_, err := client.Chat(t.Context(), req)
+	require.NoError(t, err)
+
+	stream, err := client.ChatStream(t.Context(), req)
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+	}
+
+	assert.Equal(t, Usage{PromptTokens: 14, CompletionTokens: 3, TotalTokens: 17}, tracker.Totals())
+	assert.Equal(t, 2, tracker.Requests())
+}
+
 func TestNewClient(t *testing.T) {
 	var testCases = []struct {
 		name            string
@@ -159,18 +3192,18 @@ func TestNewClient(t *testing.T) {
 		mockStatusCode  int
 		mockRawResponse string
 		mockResponse    interface{}
-		expectedToken   *tokenResponse
+		expectedToken   *Token
 		expectedError   error
 	}{
 		{
 			name:           "Success",
 			apiKey:         "testKey",
 			mockStatusCode: http.StatusOK,
-			mockResponse: &tokenResponse{
+			mockResponse: &Token{
 				AccessToken: "token",
 				ExpiresAt:   13132454545,
 			},
-			expectedToken: &tokenResponse{
+			expectedToken: &Token{
 				AccessToken: "token",
 				ExpiresAt:   13132454545,
 			},
@@ -225,14 +3258,402 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestNewClient_Scope(t *testing.T) {
+	var scopes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		scopes = append(scopes, r.PostForm.Get("scope"))
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	for _, opt := range []Option{WithCustomScope(ScopeCorp), WithUnverifiedScope("GIGACHAT_API_FUTURE")} {
+		client, err := NewClient(t.Context(), "testKey", WithCustomURLOauth(server.URL), opt)
+		require.NoError(t, err)
+		client.Close()
+	}
+	assert.Equal(t, []string{ScopeCorp, "GIGACHAT_API_FUTURE"}, scopes)
+}
+
+func TestNewClient_NoCredentials(t *testing.T) {
+	var oauthCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&oauthCalls, 1)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(t.Context(), "", WithCustomURLOauth(server.URL))
+	require.ErrorIs(t, err, ErrNoCredentials)
+	assert.Nil(t, client)
+	assert.Zero(t, atomic.LoadInt32(&oauthCalls), "no request must be made without credentials")
+}
+
+func TestNewClient_RefreshOnStart(t *testing.T) {
+	var oauthDown atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth" {
+			if oauthDown.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		_, _ = w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer server.Close()
+	opts := []Option{WithCustomURLOauth(server.URL + "/oauth"), WithCustomURLAI(server.URL), WithoutBackgroundRefresh()}
+
+	client, err := NewClient(t.Context(), "testKey", append(opts, WithRefreshOnStart(true))...)
+	require.NoError(t, err)
+	assert.True(t, client.TokenValid(), "the token must be fetched by NewClient")
+	client.Close()
+
+	oauthDown.Store(true)
+	_, err = NewClient(t.Context(), "testKey", opts...)
+	require.ErrorContains(t, err, "token fetch failed")
+
+	client, err = NewClient(t.Context(), "testKey", append(opts, WithRefreshOnStart(false))...)
+	require.NoError(t, err)
+	defer client.Close()
+	assert.False(t, client.TokenValid())
+
+	oauthDown.Store(false)
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+	assert.True(t, client.TokenValid())
+}
+
+func TestNewClient_InitialTokenRetry(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(&Token{AccessToken: "fresh", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	var before, after int
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	client, err := NewClient(t.Context(), "testKey",
+		WithOAuthURL(server.URL),
+		WithRefreshRetry(2, time.Millisecond),
+		WithTokenStore(store),
+		WithBeforeRefresh(func(ctx context.Context) { before++ }),
+		WithAfterRefresh(func(ctx context.Context, token *Token, err error) {
+			after++
+			assert.NoError(t, err)
+		}),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, before)
+	assert.Equal(t, 1, after)
+	assert.True(t, client.TokenValid())
+
+	stored, err := store.Load(t.Context())
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, "fresh", stored.AccessToken)
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	var scopes, authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth" {
+			assert.NoError(t, r.ParseForm())
+			scopes = append(scopes, r.PostForm.Get("scope"))
+			authorization = append(authorization, r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		assert.Equal(t, "/api/v1"+modelsPath, r.URL.Path)
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv(EnvAuthKey, "")
+	_, err := NewClientFromEnv(t.Context(), WithCustomURLOauth(server.URL+"/oauth"))
+	require.ErrorIs(t, err, ErrNoCredentials)
+	require.ErrorContains(t, err, "GIGACHAT_AUTH_KEY")
+
+	t.Setenv(EnvAuthKey, "envKey")
+	t.Setenv(EnvScope, ScopeCorp)
+	t.Setenv(EnvBaseURL, server.URL+"/api/v1")
+	client, err := NewClientFromEnv(t.Context(), WithCustomURLOauth(server.URL+"/oauth"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeCorp}, scopes)
+	assert.Equal(t, []string{"Basic envKey"}, authorization)
+
+	t.Setenv(EnvScope, "unknown")
+	_, err = NewClientFromEnv(t.Context(), WithCustomURLOauth(server.URL+"/oauth"))
+	require.ErrorContains(t, err, "unknown scope")
+}
+
+func TestNewClient_ClientCertificate(t *testing.T) {
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	cert := tls.Certificate{Certificate: [][]byte{{1, 2, 3}}}
+
+	testCases := []struct {
+		name          string
+		apiKey        string
+		opts          []Option
+		expectedError string
+	}{
+		{name: "Success_Certificate", opts: []Option{WithClientCertificate(cert)}},
+		{name: "Failure_NoCredentials", expectedError: "apiKey cannot be empty"},
+		{name: "Failure_BlankKey", apiKey: "  ", expectedError: "apiKey cannot be empty"},
+		{name: "Failure_Both", apiKey: "testKey", opts: []Option{WithClientCertificate(cert)}, expectedError: "mutually exclusive"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			authorization = nil
+			opts := append([]Option{WithCustomURLOauth(server.URL)}, testCase.opts...)
+			client, err := NewClient(t.Context(), testCase.apiKey, opts...)
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				assert.Empty(t, authorization)
+				return
+			}
+			require.NoError(t, err)
+			defer client.Close()
+
+			assert.Equal(t, []string{""}, authorization)
+			assert.Equal(t, []tls.Certificate{cert}, client.transport().TLSClientConfig.Certificates)
+		})
+	}
+}
+
+func TestNewClient_CredentialPool(t *testing.T) {
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		switch r.Header.Get("Authorization") {
+		case "Basic revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Basic limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(t.Context(), "", WithCustomURLOauth(server.URL), WithoutBackgroundRefresh(), WithCredentialPool([]Credential{
+		{Name: "first", AuthKey: "revoked"},
+		{Name: "second", AuthKey: "valid"},
+	}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, []string{"Basic revoked", "Basic valid"}, authorization)
+	active, ok := client.ActiveCredential()
+	require.True(t, ok)
+	assert.Equal(t, Credential{Name: "second", AuthKey: "valid"}, active)
+
+	// Later refreshes start with the active key.
+	authorization = nil
+	require.NoError(t, client.refreshToken(t.Context()))
+	assert.Equal(t, []string{"Basic valid"}, authorization)
+
+	// If every key fails, the error of the last one is returned.
+	authorization = nil
+	_, err = NewClient(t.Context(), "", WithCustomURLOauth(server.URL), WithCredentialPool([]Credential{
+		{AuthKey: "limited"},
+		{AuthKey: "revoked"},
+	}))
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, http.StatusUnauthorized, authErr.StatusCode)
+	assert.Equal(t, []string{"Basic limited", "Basic revoked"}, authorization)
+
+	_, ok = (&Client{}).ActiveCredential()
+	assert.False(t, ok)
+
+	_, err = NewClient(t.Context(), "testKey", WithCredentialPool([]Credential{{AuthKey: "valid"}}))
+	assert.ErrorContains(t, err, "mutually exclusive")
+	_, err = NewClient(t.Context(), "", WithCredentialPool([]Credential{{Name: "empty"}}))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestFileTokenStore(t *testing.T) {
+	store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+
+	token, err := store.Load(t.Context())
+	require.NoError(t, err)
+	assert.Nil(t, token)
+
+	saved := &Token{AccessToken: "cached", ExpiresAt: 1700000000000}
+	require.NoError(t, store.Save(t.Context(), saved))
+
+	info, err := os.Stat(store.Path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	token, err = store.Load(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, saved, token)
+}
+
+func TestNewClient_TokenStore(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cached         *Token
+		expectedToken  string
+		expectedOAuth  int
+		expectedCached string
+	}{
+		{
+			name:           "ValidCachedToken",
+			cached:         &Token{AccessToken: "cached", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()},
+			expectedToken:  "cached",
+			expectedCached: "cached",
+		},
+		{
+			name:           "ExpiringCachedToken",
+			cached:         &Token{AccessToken: "cached", ExpiresAt: time.Now().Add(time.Minute).UnixMilli()},
+			expectedToken:  "fresh",
+			expectedOAuth:  1,
+			expectedCached: "fresh",
+		},
+		{
+			name:           "NoCachedToken",
+			expectedToken:  "fresh",
+			expectedOAuth:  1,
+			expectedCached: "fresh",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var oauthCalls int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				oauthCalls++
+				json.NewEncoder(w).Encode(&Token{AccessToken: "fresh", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			}))
+			defer server.Close()
+
+			store := NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+			if testCase.cached != nil {
+				require.NoError(t, store.Save(t.Context(), testCase.cached))
+			}
+
+			client, err := NewClient(t.Context(), "testKey", WithCustomURLOauth(server.URL), WithTokenStore(store))
+			require.NoError(t, err)
+			defer client.Close()
+
+			assert.Equal(t, testCase.expectedToken, client.accessToken.AccessToken)
+			assert.Equal(t, testCase.expectedOAuth, oauthCalls)
+
+			cached, err := store.Load(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedCached, cached.AccessToken)
+		})
+	}
+}
+
+func TestClient_Close(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	client, err := NewClient(t.Context(), "testKey", WithCustomURLOauth(server.URL), WithCustomURLAI(server.URL))
+	require.NoError(t, err)
+
+	require.NoError(t, client.Close())
+	require.NotPanics(t, func() { client.Close() })
+
+	_, err = client.GenerativeModel("GigaChat").Generate(t.Context(), []Message{{Role: RoleUser, Content: "Hi"}})
+	require.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestClient_ClosedWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	client, err := NewClient(ctx, "testKey", WithCustomURLOauth(server.URL), WithCustomURLAI(server.URL))
+	require.NoError(t, err)
+
+	cancel()
+	require.Eventually(t, client.closed.Load, time.Second, time.Millisecond)
+
+	_, err = client.Models(t.Context())
+	require.ErrorIs(t, err, ErrClientClosed)
+	require.NoError(t, client.Close())
+}
+
+func TestClient_ConcurrentChat(t *testing.T) {
+	const goroutines = 300
+
+	var tokenRequests atomic.Int32
+	serverOauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := tokenRequests.Add(1)
+		json.NewEncoder(w).Encode(&Token{AccessToken: fmt.Sprintf("token-%d", n), ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer serverOauth.Close()
+
+	// The first token is revoked, so every early request hits a 401 and refreshes concurrently.
+	serverAI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req ChatRequest
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			return
+		}
+		json.NewEncoder(w).Encode(&ChatResponse{Choices: []Choice{{Message: ResponseMessage{Role: RoleAssistant, Content: req.Messages[0].Content}}}})
+	}))
+	defer serverAI.Close()
+
+	client, err := NewClient(t.Context(), "testKey", WithCustomURLOauth(serverOauth.URL), WithCustomURLAI(serverAI.URL))
+	require.NoError(t, err)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := strconv.Itoa(i)
+			resp, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: content}}})
+			if assert.NoError(t, err) {
+				assert.Equal(t, content, resp.Choices[0].Message.Content)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 2, tokenRequests.Load())
+}
+
 func TestClient_isValid(t *testing.T) {
 
-	c := &Client{}
+	c := &Client{refreshBuffer: defaultTokenRefreshBuffer}
 
 	testNow := time.Date(2023, 10, 27, 10, 0, 0, 0, time.UTC)
 	testNowMs := testNow.UnixNano() / int64(time.Millisecond)
 
-	fifteenMinutesMs := int64(tokenRefreshBuffer / time.Millisecond)
+	fifteenMinutesMs := int64(defaultTokenRefreshBuffer / time.Millisecond)
 
 	testCases := []struct {
 		name      string
@@ -240,37 +3661,220 @@ func TestClient_isValid(t *testing.T) {
 		expected  bool
 	}{
 		{
-			name:      "Token is valid (expires in 1 hour)",
-			expiresAt: testNowMs + (60 * 60 * 1000),
-			expected:  true,
+			name:      "Token is valid (expires in 1 hour)",
+			expiresAt: testNowMs + (60 * 60 * 1000),
+			expected:  true,
+		},
+		{
+			name:      "Token is almost expired (expires in 16 minutes)",
+			expiresAt: testNowMs + (16 * 60 * 1000),
+			expected:  true,
+		},
+		{
+			name:      "Token is at the boundary (expires in exactly 15 minutes)",
+			expiresAt: testNowMs + fifteenMinutesMs,
+			expected:  false,
+		},
+		{
+			name:      "Token needs refresh (expires in 14 minutes)",
+			expiresAt: testNowMs + (14 * 60 * 1000),
+			expected:  false,
+		},
+		{
+			name:      "Token is expired (expired 1 hour ago)",
+			expiresAt: testNowMs - (60 * 60 * 1000),
+			expected:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			actual := c.isValid(tc.expiresAt, testNow)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestClient_isValidCustomBuffer(t *testing.T) {
+	c := &Client{refreshBuffer: time.Minute}
+
+	testNow := time.Date(2023, 10, 27, 10, 0, 0, 0, time.UTC)
+
+	assert.True(t, c.isValid(testNow.Add(2*time.Minute).UnixMilli(), testNow))
+	assert.False(t, c.isValid(testNow.Add(30*time.Second).UnixMilli(), testNow))
+}
+
+func TestNewClient_APIURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opt      func(url string) Option
+		path     string
+		expected []string
+	}{
+		{
+			name:     "CustomURLAI_CompletionsURL",
+			opt:      WithCustomURLAI,
+			path:     "/api/v1/chat/completions",
+			expected: []string{"/api/v1/chat/completions", "/api/v1/models"},
+		},
+		{
+			name:     "CustomURLAI_CompletionsURLWithTrailingSlash",
+			opt:      WithCustomURLAI,
+			path:     "/api/v1/chat/completions/",
+			expected: []string{"/api/v1/chat/completions", "/api/v1/models"},
+		},
+		{
+			// A URL without the suffix is taken as the base URL.
+			name:     "CustomURLAI_WithoutCompletionsSuffix",
+			opt:      WithCustomURLAI,
+			path:     "/proxy/gigachat",
+			expected: []string{"/proxy/gigachat/chat/completions", "/proxy/gigachat/models"},
+		},
+		{
+			name:     "APIBaseURL",
+			opt:      WithAPIBaseURL,
+			path:     "/api/v1",
+			expected: []string{"/api/v1/chat/completions", "/api/v1/models"},
+		},
+		{
+			name:     "APIBaseURLWithTrailingSlash",
+			opt:      WithAPIBaseURL,
+			path:     "/api/v1/",
+			expected: []string{"/api/v1/chat/completions", "/api/v1/models"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				if strings.HasSuffix(r.URL.Path, modelsPath) {
+					w.Write([]byte(`{"data":[]}`))
+					return
+				}
+				w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, nil, tc.opt(server.URL+tc.path))
+
+			_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+			require.NoError(t, err)
+			_, err = client.Models(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, paths)
+		})
+	}
+}
+
+func TestNewClient_OAuthURL(t *testing.T) {
+	for name, opt := range map[string]func(string) Option{
+		"OAuthURL":       WithOAuthURL,
+		"CustomURLOauth": WithCustomURLOauth,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			}))
+			defer server.Close()
+
+			client, err := NewClient(t.Context(), "testKey", opt(server.URL+"/api/v2/oauth"))
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, []string{"/api/v2/oauth"}, paths)
+		})
+	}
+}
+
+func TestNewClient_ConfigValidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name          string
+		opts          []Option
+		expectedError string
+	}{
+		{
+			name: "Success_CustomValues",
+			opts: []Option{WithTokenRefreshBuffer(time.Minute), WithTokenRefreshInterval(time.Second)},
+		},
+		{
+			name:          "Failure_ZeroBuffer",
+			opts:          []Option{WithTokenRefreshBuffer(0)},
+			expectedError: "token refresh buffer must be positive",
+		},
+		{
+			name:          "Failure_NegativeMaxIdleConnsPerHost",
+			opts:          []Option{WithMaxIdleConnsPerHost(-1)},
+			expectedError: "max idle connections per host must not be negative",
+		},
+		{
+			name:          "Failure_ZeroMaxResponseBytes",
+			opts:          []Option{WithMaxResponseBytes(0)},
+			expectedError: "max response bytes must be positive",
+		},
+		{
+			name:          "Failure_NegativeStreamBuffer",
+			opts:          []Option{WithStreamRefreshBuffer(-time.Second)},
+			expectedError: "stream refresh buffer must not be negative",
+		},
+		{
+			name:          "Failure_MalformedAIURL",
+			opts:          []Option{WithCustomURLAI("gigachat.local/api/v1")},
+			expectedError: "invalid AI API URL",
+		},
+		{
+			name:          "Failure_MalformedOauthURL",
+			opts:          []Option{WithCustomURLOauth("http://")},
+			expectedError: "invalid OAuth URL",
+		},
+		{
+			name:          "Failure_ResponseValidatorAttempts",
+			opts:          []Option{WithResponseValidator(func(*ChatResponse) error { return nil }, 0)},
+			expectedError: "response validator attempts must be positive",
 		},
 		{
-			name:      "Token is almost expired (expires in 16 minutes)",
-			expiresAt: testNowMs + (16 * 60 * 1000),
-			expected:  true,
+			name:          "Failure_MalformedOAuthURLOption",
+			opts:          []Option{WithOAuthURL("http://")},
+			expectedError: "invalid OAuth URL",
 		},
 		{
-			name:      "Token is at the boundary (expires in exactly 15 minutes)",
-			expiresAt: testNowMs + fifteenMinutesMs,
-			expected:  false,
+			name:          "Failure_UnknownScope",
+			opts:          []Option{WithCustomScope("GIGACHAT_API_FREE")},
+			expectedError: "unknown scope",
 		},
 		{
-			name:      "Token needs refresh (expires in 14 minutes)",
-			expiresAt: testNowMs + (14 * 60 * 1000),
-			expected:  false,
+			name:          "Failure_NegativeEmbeddingBatchSize",
+			opts:          []Option{WithEmbeddingBatchSize(-1)},
+			expectedError: "embeddings batch size cannot be negative",
 		},
 		{
-			name:      "Token is expired (expired 1 hour ago)",
-			expiresAt: testNowMs - (60 * 60 * 1000),
-			expected:  false,
+			name:          "Failure_NegativeInterval",
+			opts:          []Option{WithTokenRefreshInterval(-time.Second)},
+			expectedError: "token refresh interval must be positive",
 		},
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-
-			actual := c.isValid(tc.expiresAt, testNow)
-			assert.Equal(t, tc.expected, actual)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			opts := append([]Option{WithCustomURLOauth(server.URL)}, testCase.opts...)
+			client, err := NewClient(t.Context(), "testKey", opts...)
+			if testCase.expectedError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, time.Minute, client.refreshBuffer)
+			assert.Equal(t, time.Second, client.refreshInterval)
 		})
 	}
 }
@@ -282,7 +3886,7 @@ func TestClient_Refresh(t *testing.T) {
 		response        interface{}
 		mockRawResponse string
 		mockStatusCode  int
-		expectedOutput  *tokenResponse
+		expectedOutput  *Token
 		expectedError   error
 	}{
 		{
@@ -290,11 +3894,11 @@ func TestClient_Refresh(t *testing.T) {
 			apiKey:         "fakeKey",
 			mockStatusCode: http.StatusOK,
 			expectedError:  nil,
-			response: &tokenResponse{
+			response: &Token{
 				AccessToken: "token",
 				ExpiresAt:   13132454545,
 			},
-			expectedOutput: &tokenResponse{
+			expectedOutput: &Token{
 				AccessToken: "token",
 				ExpiresAt:   13132454545,
 			},
@@ -339,7 +3943,7 @@ func TestClient_Refresh(t *testing.T) {
 			client := &Client{
 				httpClient:   &http.Client{},
 				baseURLOauth: server.URL,
-				accessToken: &tokenResponse{
+				accessToken: &Token{
 					AccessToken: "token",
 					ExpiresAt:   13132454545,
 				},
@@ -358,16 +3962,486 @@ func TestClient_Refresh(t *testing.T) {
 	}
 }
 
+func TestClient_nextRefreshDelay(t *testing.T) {
+	c := &Client{refreshInterval: time.Minute}
+	assert.Equal(t, time.Minute, c.nextRefreshDelay())
+
+	c.refreshJitter = 10 * time.Second
+	for i := 0; i < 100; i++ {
+		delay := c.nextRefreshDelay()
+		require.GreaterOrEqual(t, delay, time.Minute)
+		require.Less(t, delay, time.Minute+10*time.Second)
+	}
+}
+
+func TestClient_RefreshAuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":6,"message":"credentials doesn't match db data"}`))
+	}))
+	defer server.Close()
+
+	client := &Client{
+		httpClient:   &http.Client{},
+		baseURLOauth: server.URL,
+	}
+
+	err := client.refreshToken(t.Context())
+	var authErr *AuthError
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, http.StatusUnauthorized, authErr.StatusCode)
+	assert.Contains(t, string(authErr.Body), "credentials doesn't match db data")
+	assert.True(t, authErr.Permanent())
+	assert.False(t, (&AuthError{StatusCode: http.StatusInternalServerError}).Permanent())
+}
+
+func TestClient_TokenRefresherStopsOnPermanentAuthError(t *testing.T) {
+	var callCount int32
+
+	client := &Client{
+		refreshBuffer:   defaultTokenRefreshBuffer,
+		refreshInterval: 10 * time.Millisecond,
+		wg:              &sync.WaitGroup{},
+		accessToken:     &Token{AccessToken: "token", ExpiresAt: time.Now().UnixMilli()},
+	}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&callCount, 1)
+		return nil, &AuthError{StatusCode: http.StatusUnauthorized}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		client.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("tokenRefresher did not stop after a permanent auth error")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callCount))
+}
+
+func TestClient_TokenRefresherStopsDuringRefresh(t *testing.T) {
+	logger := &recordingLogger{}
+	started := make(chan struct{}, 1)
+
+	client := &Client{
+		refreshBuffer:      defaultTokenRefreshBuffer,
+		refreshInterval:    10 * time.Millisecond,
+		refreshMaxAttempts: 5,
+		refreshBaseDelay:   time.Hour,
+		logger:             logger,
+		wg:                 &sync.WaitGroup{},
+		accessToken:        &Token{AccessToken: "token", ExpiresAt: time.Now().UnixMilli()},
+	}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// A slow OAuth endpoint answering with a retryable error.
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+		}
+		return nil, &AuthError{StatusCode: http.StatusServiceUnavailable}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+
+	<-started
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		client.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("tokenRefresher did not stop while refreshing")
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	assert.Empty(t, logger.lines)
+}
+
+func TestClient_CloseAbortsRefresh(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{
+		httpClient:      &http.Client{},
+		refreshBuffer:   defaultTokenRefreshBuffer,
+		refreshInterval: time.Millisecond,
+		logger:          &recordingLogger{},
+		wg:              &sync.WaitGroup{},
+		ctxCancel:       cancel,
+		accessToken:     &Token{AccessToken: "token", ExpiresAt: time.Now().UnixMilli()},
+	}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		close(started)
+		// Block like a request to an unresponsive OAuth endpoint.
+		<-ctx.Done()
+		aborted <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+	<-started
+
+	start := time.Now()
+	require.NoError(t, client.Close())
+	assert.Less(t, time.Since(start), time.Second)
+	require.ErrorIs(t, <-aborted, context.Canceled)
+}
+
+func TestClient_TokenRefresherErrorHandler(t *testing.T) {
+	errCh := make(chan error, 1)
+
+	client := &Client{
+		refreshBuffer:   defaultTokenRefreshBuffer,
+		refreshInterval: 10 * time.Millisecond,
+		wg:              &sync.WaitGroup{},
+		accessToken:     &Token{AccessToken: "token", ExpiresAt: time.Now().UnixMilli()},
+	}
+	client.refreshErrorHandler = func(err error) {
+		// Re-entering the client must not deadlock.
+		client.mu.RLock()
+		client.mu.RUnlock()
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		return nil, errors.New("network is unreachable")
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer func() {
+		cancel()
+		client.wg.Wait()
+	}()
+
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+
+	select {
+	case err := <-errCh:
+		require.ErrorContains(t, err, "network is unreachable")
+	case <-time.After(time.Second):
+		t.Fatal("refresh error handler was not called")
+	}
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestClient_Logger(t *testing.T) {
+	logger := &recordingLogger{}
+	client := &Client{logger: logger}
+
+	client.reportRefreshError(&AuthError{StatusCode: http.StatusUnauthorized})
+
+	require.Len(t, logger.lines, 2)
+	assert.Contains(t, logger.lines[0], "failed to refresh token in background")
+	assert.Contains(t, logger.lines[1], "credentials rejected")
+}
+
+func TestClient_RequestLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}, WithRequestLogging(logger, 10))
+
+	_, err := client.Chat(t.Context(), &ChatRequest{Model: "GigaChat", Messages: []Message{{Role: RoleUser, Content: "Hi"}}})
+	require.NoError(t, err)
+
+	require.Len(t, logger.lines, 2)
+	for _, line := range logger.lines {
+		assert.Contains(t, line, `Authorization="***"`)
+		assert.NotContains(t, line, "testKey")
+		assert.NotContains(t, line, "Bearer")
+	}
+	assert.Contains(t, logger.lines[0], "gigago: request POST http://")
+	assert.Contains(t, logger.lines[0], `body="scope=GIGA" (truncated)`)
+	assert.Contains(t, logger.lines[1], "/chat/completions")
+	assert.Contains(t, logger.lines[1], `Content-Type="application/json"`)
+	assert.Contains(t, logger.lines[1], `body="{\"model\":\"" (truncated)`)
+}
+
+func TestClient_RefreshRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		errs          []error
+		expectedCalls int32
+		expectedError string
+	}{
+		{
+			name:          "Success_AfterTransientErrors",
+			errs:          []error{&AuthError{StatusCode: http.StatusBadGateway}, errors.New("connection reset")},
+			expectedCalls: 3,
+		},
+		{
+			name:          "Failure_NoRetryOnRejectedCredentials",
+			errs:          []error{&AuthError{StatusCode: http.StatusUnauthorized}},
+			expectedCalls: 1,
+			expectedError: "oauth request failed with status 401",
+		},
+		{
+			name: "Failure_AttemptsExhausted",
+			errs: []error{
+				&AuthError{StatusCode: http.StatusServiceUnavailable},
+				&AuthError{StatusCode: http.StatusServiceUnavailable},
+				&AuthError{StatusCode: http.StatusServiceUnavailable},
+			},
+			expectedCalls: 3,
+			expectedError: "oauth request failed with status 503",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var callCount int32
+			client := &Client{refreshMaxAttempts: 3, refreshBaseDelay: time.Millisecond}
+			client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+				n := atomic.AddInt32(&callCount, 1)
+				if int(n) <= len(testCase.errs) {
+					return nil, testCase.errs[n-1]
+				}
+				return &Token{AccessToken: "fresh"}, nil
+			}
+
+			err := client.refreshToken(t.Context())
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "fresh", client.accessToken.AccessToken)
+			}
+			assert.Equal(t, testCase.expectedCalls, atomic.LoadInt32(&callCount))
+		})
+	}
+}
+
+func TestClient_RefreshRetryStopsOnContextCancel(t *testing.T) {
+	var callCount int32
+	client := &Client{refreshMaxAttempts: 5, refreshBaseDelay: time.Hour}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&callCount, 1)
+		return nil, &AuthError{StatusCode: http.StatusInternalServerError}
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.refreshToken(ctx)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callCount))
+}
+
+func TestClient_EnsureToken(t *testing.T) {
+	testCases := []struct {
+		name          string
+		accessToken   *Token
+		expectedCalls int32
+	}{
+		{
+			name:          "NoToken",
+			accessToken:   nil,
+			expectedCalls: 1,
+		},
+		{
+			name:          "ExpiringToken",
+			accessToken:   &Token{AccessToken: "old", ExpiresAt: time.Now().Add(time.Minute).UnixMilli()},
+			expectedCalls: 1,
+		},
+		{
+			name:          "ValidToken",
+			accessToken:   &Token{AccessToken: "fresh", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()},
+			expectedCalls: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var callCount int32
+			client := &Client{refreshBuffer: defaultTokenRefreshBuffer, accessToken: testCase.accessToken}
+			client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+				atomic.AddInt32(&callCount, 1)
+				return &Token{AccessToken: "fresh", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+			}
+
+			require.NoError(t, client.EnsureToken(t.Context()))
+			assert.Equal(t, "fresh", client.accessToken.AccessToken)
+			assert.Equal(t, testCase.expectedCalls, atomic.LoadInt32(&callCount))
+		})
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	status := http.StatusOK
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, modelsPath, r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	})
+
+	require.NoError(t, client.Ping(t.Context()))
+
+	status = http.StatusServiceUnavailable
+	err := client.Ping(t.Context())
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+}
+
+func TestClient_WithoutBackgroundRefresh(t *testing.T) {
+	var oauthCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth" {
+			atomic.AddInt32(&oauthCalls, 1)
+			json.NewEncoder(w).Encode(&Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()})
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(t.Context(), "testKey",
+		WithCustomURLOauth(server.URL+"/oauth"),
+		WithCustomURLAI(server.URL),
+		WithTokenRefreshInterval(time.Millisecond),
+		WithoutBackgroundRefresh(),
+	)
+	require.NoError(t, err)
+
+	client.mu.Lock()
+	client.accessToken.ExpiresAt = time.Now().Add(time.Minute).UnixMilli()
+	client.mu.Unlock()
+
+	// A background refresher would have replaced the stale token by now.
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&oauthCalls))
+	assert.False(t, client.TokenValid())
+
+	_, err = client.Models(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&oauthCalls))
+
+	require.NoError(t, client.Close())
+	require.NoError(t, client.Close())
+}
+
+func TestClient_isExpired(t *testing.T) {
+	c := &Client{}
+	now := time.Date(2023, 10, 27, 10, 0, 0, 0, time.UTC)
+
+	assert.False(t, c.isExpired(now.Add(time.Second).UnixMilli(), now))
+	assert.True(t, c.isExpired(now.UnixMilli(), now))
+	assert.True(t, c.isExpired(now.Add(-time.Minute).UnixMilli(), now))
+}
+
+func TestClient_RequestTokenDegradation(t *testing.T) {
+	testCases := []struct {
+		name          string
+		expiresIn     time.Duration
+		expectedToken string
+		expectedError string
+	}{
+		{
+			name:          "InsideBuffer_UsesCurrentToken",
+			expiresIn:     5 * time.Minute,
+			expectedToken: "Bearer current",
+		},
+		{
+			name:          "Expired_Fails",
+			expiresIn:     -time.Minute,
+			expectedError: "failed to obtain access token",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var tokens []string
+			client := &Client{
+				baseURLAI:     "http://api.test",
+				refreshBuffer: defaultTokenRefreshBuffer,
+				logger:        &recordingLogger{},
+				accessToken:   &Token{AccessToken: "current", ExpiresAt: time.Now().Add(testCase.expiresIn).UnixMilli()},
+				httpClient: &http.Client{
+					Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+						tokens = append(tokens, r.Header.Get("Authorization"))
+						return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"data":[]}`))}, nil
+					}),
+				},
+			}
+			client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+				return nil, &AuthError{StatusCode: http.StatusServiceUnavailable}
+			}
+
+			_, err := client.Models(t.Context())
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				assert.Empty(t, tokens)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{testCase.expectedToken}, tokens)
+		})
+	}
+}
+
+func TestClient_TokenExpiresAt(t *testing.T) {
+	client := &Client{refreshBuffer: defaultTokenRefreshBuffer}
+	assert.True(t, client.TokenExpiresAt().IsZero())
+	assert.False(t, client.TokenValid())
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	client.accessToken = &Token{AccessToken: "token", ExpiresAt: expiresAt.UnixMilli()}
+	assert.True(t, expiresAt.Equal(client.TokenExpiresAt()))
+	assert.True(t, client.TokenValid())
+
+	client.accessToken.ExpiresAt = time.Now().Add(time.Minute).UnixMilli()
+	assert.False(t, client.TokenValid())
+}
+
 func TestClient_ConcurrentRefreshToken(t *testing.T) {
 	var callCount int32
 	const goroutines = 10
 
 	client := &Client{}
 
-	client.oauthCreateFunc = func(ctx context.Context) (*tokenResponse, error) {
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
 		atomic.AddInt32(&callCount, 1)
 		time.Sleep(50 * time.Millisecond) // simulate network delay
-		return &tokenResponse{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+		return &Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
 	}
 
 	var wg sync.WaitGroup
@@ -387,3 +4461,265 @@ func TestClient_ConcurrentRefreshToken(t *testing.T) {
 	}
 	require.Equal(t, int32(1), callCount, "oauthCreate должен быть вызван только один раз")
 }
+
+func TestClient_TokenRefresherWithoutToken(t *testing.T) {
+	refreshed := make(chan struct{})
+	var once sync.Once
+	client := &Client{
+		wg:              &sync.WaitGroup{},
+		refreshInterval: time.Millisecond,
+		oauthCreateFunc: func(ctx context.Context) (*Token, error) {
+			once.Do(func() { close(refreshed) })
+			return &Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a missing token must be refreshed")
+	}
+	cancel()
+	client.wg.Wait()
+	assert.True(t, client.TokenValid())
+}
+
+func TestClient_RefreshHooks(t *testing.T) {
+	const goroutines = 10
+	var (
+		before, after int32
+		fail          atomic.Bool
+		gotToken      *Token
+		gotErr        error
+	)
+
+	client := &Client{}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		time.Sleep(50 * time.Millisecond) // let all callers join the refresh
+		if fail.Load() {
+			return nil, &AuthError{StatusCode: http.StatusUnauthorized}
+		}
+		return &Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+	}
+	WithBeforeRefresh(func(ctx context.Context) {
+		atomic.AddInt32(&before, 1)
+		client.TokenExpiresAt() // the client's locks are not held
+	})(client)
+	WithAfterRefresh(func(ctx context.Context, token *Token, err error) {
+		atomic.AddInt32(&after, 1)
+		gotToken, gotErr = token, err
+		assert.Equal(t, token != nil, client.TokenValid())
+	})(client)
+
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.refreshToken(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&before))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&after))
+	require.NotNil(t, gotToken)
+	assert.Equal(t, "token", gotToken.AccessToken)
+	assert.NoError(t, gotErr)
+
+	client.accessToken = nil
+	fail.Store(true)
+	var authErr *AuthError
+	require.ErrorAs(t, client.refreshToken(context.Background()), &authErr)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&before))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&after))
+	assert.Nil(t, gotToken)
+	assert.ErrorAs(t, gotErr, &authErr)
+}
+
+func TestClient_ConcurrentUnauthorizedRefreshesOnce(t *testing.T) {
+	var callCount int32
+	const goroutines = 100
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&callCount, 1)
+		return &Token{AccessToken: "token-2", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, goroutines)
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = client.Models(t.Context())
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), callCount)
+}
+
+func TestClient_RefreshWaiterContextCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	client := &Client{}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		close(started)
+		<-release
+		return &Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).UnixMilli()}, nil
+	}
+
+	leaderErr := make(chan error, 1)
+	go func() { leaderErr <- client.refreshToken(context.Background()) }()
+	<-started
+
+	ctx, cancel := context.WithCancel(t.Context())
+	waiterErr := make(chan error, 1)
+	go func() { waiterErr <- client.refreshToken(ctx) }()
+
+	require.Eventually(t, func() bool {
+		client.refreshMu.Lock()
+		defer client.refreshMu.Unlock()
+		return len(client.refreshWaiters) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-waiterErr:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("waiter did not return after its context was cancelled")
+	}
+
+	close(release)
+	require.NoError(t, <-leaderErr)
+	assert.Equal(t, "token", client.accessToken.AccessToken)
+}
+
+// fakeClock is a Clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// resets receives a value whenever a timer is reset.
+	resets chan struct{}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, resets: make(chan struct{}, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, c: make(chan time.Time, 1), deadline: c.now.Add(d), active: true}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, timer := range c.timers {
+		if timer.active && !timer.deadline.After(c.now) {
+			timer.active = false
+			timer.c <- c.now
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	wasActive := t.active
+	t.deadline, t.active = t.clock.now.Add(d), true
+	t.clock.mu.Unlock()
+
+	t.clock.resets <- struct{}{}
+	return wasActive
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func TestClient_TokenRefresherWithClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2023, 10, 27, 10, 0, 0, 0, time.UTC))
+	var callCount int32
+
+	client := &Client{
+		refreshBuffer:   defaultTokenRefreshBuffer,
+		refreshInterval: time.Minute,
+		clock:           clock,
+		wg:              &sync.WaitGroup{},
+		accessToken:     &Token{AccessToken: "old", ExpiresAt: clock.Now().Add(17 * time.Minute).UnixMilli()},
+	}
+	client.oauthCreateFunc = func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&callCount, 1)
+		return &Token{AccessToken: "new", ExpiresAt: clock.Now().Add(time.Hour).UnixMilli()}, nil
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer func() {
+		cancel()
+		client.wg.Wait()
+	}()
+
+	client.wg.Add(1)
+	go client.tokenRefresher(ctx)
+
+	// Wait for the refresher to create its timer.
+	require.Eventually(t, func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) == 1
+	}, time.Second, time.Millisecond)
+
+	// 16 minutes before expiration the token is still outside the buffer.
+	clock.Advance(time.Minute)
+	<-clock.resets
+	assert.Equal(t, int32(0), atomic.LoadInt32(&callCount))
+	assert.True(t, client.TokenValid())
+
+	// 15 minutes before expiration it is refreshed.
+	clock.Advance(time.Minute)
+	<-clock.resets
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callCount))
+	assert.Equal(t, "new", client.accessToken.AccessToken)
+}
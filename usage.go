@@ -0,0 +1,64 @@
+package gigago
+
+import "sync"
+
+// UsageStats is the former name of Usage.
+//
+// Deprecated: Use Usage instead.
+type UsageStats = Usage
+
+// UsageTracker accumulates token usage across requests, e.g. to track the cost
+// of a conversation. It is safe for concurrent use. The zero value is ready to use.
+//
+// Pass a tracker to WithUsageTracker to have the client record the usage of every
+// chat completion and stream automatically, or call Add yourself.
+type UsageTracker struct {
+	mu       sync.Mutex
+	totals   Usage
+	requests int
+}
+
+// Add adds u to the running totals. Calling Add on a nil tracker does nothing.
+func (t *UsageTracker) Add(u Usage) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals.add(u)
+	t.requests++
+}
+
+// Totals returns the usage accumulated so far.
+func (t *UsageTracker) Totals() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.totals
+}
+
+// Requests returns the number of usage reports added so far.
+func (t *UsageTracker) Requests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.requests
+}
+
+// WithUsageTracker provides an Option to record the token usage reported by every
+// chat completion (Chat, Generate) and by the final chunk of every stream in tracker.
+func WithUsageTracker(tracker *UsageTracker) Option {
+	return func(c *Client) {
+		c.usageTracker = tracker
+	}
+}
+
+// add adds the token counts of v to u.
+func (u *Usage) add(v Usage) {
+	u.PromptTokens += v.PromptTokens
+	u.CompletionTokens += v.CompletionTokens
+	u.PrecachedPromptTokens += v.PrecachedPromptTokens
+	u.TotalTokens += v.TotalTokens
+}
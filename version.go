@@ -0,0 +1,8 @@
+package gigago
+
+// Version is the version of the gigago module. It is sent in the default
+// User-Agent header as "gigago/<Version>".
+const Version = "0.1.0"
+
+// defaultUserAgent is the User-Agent sent with every request unless WithUserAgent is used.
+const defaultUserAgent = "gigago/" + Version